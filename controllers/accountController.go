@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jwtauth/database"
+	helper "jwtauth/helpers"
+	"jwtauth/models"
+	"jwtauth/services"
+)
+
+var accountRestoreCollection *mongo.Collection = database.OpenCollection(database.Client, "account_restores")
+
+// DeleteUser closes the account. It is only marked deleted, its sessions are ended and the owner
+// gets a link to restore it until the grace period is over, then PurgeDeletedAccounts removes it.
+func DeleteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.Param("user_id")
+
+		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// the filter makes sure two requests don't both close it.
+		now := time.Now()
+		var user models.User
+		err := userCollection.FindOneAndUpdate(ctx,
+			bson.M{"user_id": userId, "deleted_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"deleted_at": now, "updated_at": now}}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while deleting the user"})
+			return
+		}
+
+		if err = helper.RevokeUserSessions(ctx, userId, helper.RevokedAccountDeleted); err != nil {
+			log.Printf("Failed to revoke the sessions of a deleted user: %v", err)
+		}
+		helper.RecordAudit(c, helper.AuditAccountDeleted, userId, helper.AuditSuccess, nil)
+
+		purgeAt := now.Add(services.AccountDeletionGrace())
+		token := services.GenerateSecureToken()
+		_, err = accountRestoreCollection.InsertOne(ctx, models.AccountRestore{
+			User_id:    userId,
+			Token_hash: services.HashToken(token),
+			Expires_at: purgeAt,
+			Created_at: now,
+		})
+		if err != nil {
+			log.Printf("Failed to store the restore link: %v", err)
+		} else {
+			emailService := services.NewEmailService()
+			if err = emailService.SendAccountDeletedNotice(*user.Email, *user.First_name, languageOf(user), token, purgeAt); err != nil {
+				log.Printf("Failed to queue account deleted notice: %v", err)
+			}
+		}
+
+		if userId == c.GetString("uid") {
+			helper.ClearSessionCookies(c)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Account closed. It can be restored with the link sent by email until it is permanently deleted.",
+			"purge_at": purgeAt,
+		})
+	}
+}
+
+// RestoreAccount is the link sent when the account was closed, it opens the account again.
+func RestoreAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "restore token is required"})
+			return
+		}
+
+		var restore models.AccountRestore
+		err := accountRestoreCollection.FindOneAndDelete(ctx, bson.M{
+			"token_hash": services.HashToken(token),
+			"expires_at": bson.M{"$gt": time.Now()},
+		}).Decode(&restore)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired restore token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while restoring the account"})
+			return
+		}
+
+		result, err := userCollection.UpdateOne(ctx,
+			bson.M{"user_id": restore.User_id, "deleted_at": bson.M{"$exists": true}},
+			bson.M{"$unset": bson.M{"deleted_at": ""}, "$set": bson.M{"updated_at": time.Now()}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while restoring the account"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired restore token"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditAccountRestored, restore.User_id, helper.AuditSuccess, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Account restored. You can now login."})
+	}
+}
+
+// PurgeDeletedAccounts removes for good the accounts closed longer than the grace period ago,
+// with everything kept about them. The audit log is kept.
+func PurgeDeletedAccounts(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-services.AccountDeletionGrace())
+	cursor, err := userCollection.Find(ctx, bson.M{"deleted_at": bson.M{"$lte": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	var users []models.User
+	if err = cursor.All(ctx, &users); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		if err = purgeAccount(ctx, user); err != nil {
+			log.Printf("Failed to purge user %s: %v", user.User_id, err)
+			continue
+		}
+		helper.RecordSystemAudit(helper.AuditAccountPurged, user.User_id, helper.AuditSuccess, nil)
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeAccount deletes the records of the user first and the user last, so a failure is retried on the next run.
+func purgeAccount(ctx context.Context, user models.User) error {
+	byUser := bson.M{"user_id": user.User_id}
+
+	// the emails queued for the user go too, whatever their status, also the ones to the addresses of
+	// the email changes, like the confirmation link, so this runs before those records are deleted.
+	addresses, err := userAddresses(ctx, user)
+	if err != nil {
+		return err
+	}
+	if err := services.DeleteOutboxMessagesTo(ctx, addresses); err != nil {
+		return err
+	}
+
+	for _, collection := range []*mongo.Collection{
+		loginHistoryCollection,
+		passwordHistoryCollection,
+		passwordResetCollection,
+		phoneVerificationCollection,
+		emailChangeCollection,
+		accountRestoreCollection,
+	} {
+		if _, err := collection.DeleteMany(ctx, byUser); err != nil {
+			return err
+		}
+	}
+	if err := helper.DeleteUserSessions(ctx, user.User_id); err != nil {
+		return err
+	}
+	if user.Email != nil {
+		if _, err := loginAttemptCollection.DeleteMany(ctx, bson.M{"email": lockoutKey(*user.Email)}); err != nil {
+			return err
+		}
+	}
+	_, err = userCollection.DeleteOne(ctx, bson.M{"user_id": user.User_id, "deleted_at": bson.M{"$exists": true}})
+	return err
+}
+
+// userAddresses is the email of the user and the old and new emails of their email changes, without
+// the ones another account uses now, the mail queued for that account is not ours to delete.
+func userAddresses(ctx context.Context, user models.User) ([]string, error) {
+	var changes []models.EmailChange
+	cursor, err := emailChangeCollection.Find(ctx, bson.M{"user_id": user.User_id})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+
+	candidates := []string{}
+	if user.Email != nil {
+		candidates = append(candidates, *user.Email)
+	}
+	for _, change := range changes {
+		candidates = append(candidates, change.Old_email, change.New_email)
+	}
+
+	addresses := []string{}
+	seen := map[string]bool{}
+	for _, address := range candidates {
+		if address == "" || seen[address] {
+			continue
+		}
+		seen[address] = true
+		count, err := userCollection.CountDocuments(ctx, bson.M{"email": address, "user_id": bson.M{"$ne": user.User_id}})
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+// RunAccountPurger runs PurgeDeletedAccounts every ACCOUNT_PURGE_INTERVAL, it blocks until ctx is cancelled.
+func RunAccountPurger(ctx context.Context) {
+	ticker := time.NewTicker(services.AccountPurgeInterval())
+	defer ticker.Stop()
+	for {
+		purgeCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+		purged, err := PurgeDeletedAccounts(purgeCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("account purge: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("account purge: removed %d accounts", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
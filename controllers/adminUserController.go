@@ -0,0 +1,371 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	helper "jwtauth/helpers"
+	"jwtauth/models"
+	"jwtauth/services"
+)
+
+// the handlers of this file are behind the /admin/users group, which only lets the ADMIN users through.
+
+// UnlockUser clears the failed logins of a locked account.
+func UnlockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": c.Param("user_id")}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err = loginAttemptCollection.DeleteOne(ctx, bson.M{"email": lockoutKey(*user.Email)}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while unlocking the user"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditUnlock, user.User_id, helper.AuditSuccess, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "user unlocked"})
+	}
+}
+
+// SuspendUser stops a user from using the account, until reactivated or until the optional expiry.
+// The sessions of the user are ended, so the tokens already handed out stop working too.
+func SuspendUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Reason string     `json:"reason" validate:"required,max=500"`
+			Until  *time.Time `json:"until"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Until != nil && !body.Until.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until must be in the future"})
+			return
+		}
+
+		userId := c.Param("user_id")
+		if userId == c.GetString("uid") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "you can't suspend your own account"})
+			return
+		}
+
+		set := bson.M{"suspended_at": time.Now(), "suspension_reason": body.Reason, "updated_at": time.Now()}
+		update := bson.M{"$set": set}
+		if body.Until != nil {
+			set["suspended_until"] = *body.Until
+		} else {
+			update["$unset"] = bson.M{"suspended_until": ""}
+		}
+
+		var user models.User
+		err := userCollection.FindOneAndUpdate(ctx, bson.M{"user_id": userId}, update).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while suspending the user"})
+			return
+		}
+
+		if err = helper.RevokeUserSessions(ctx, userId, helper.RevokedAccountSuspended); err != nil {
+			log.Printf("Failed to revoke the sessions of a suspended user: %v", err)
+		}
+		details := map[string]interface{}{"reason": body.Reason}
+		if body.Until != nil {
+			details["until"] = *body.Until
+		}
+		helper.RecordAudit(c, helper.AuditAccountSuspended, userId, helper.AuditSuccess, details)
+		notifySuspension(user, body.Reason, body.Until)
+		c.JSON(http.StatusOK, gin.H{"message": "user suspended"})
+	}
+}
+
+// ReactivateUser lifts the suspension of a user, who has to login again.
+func ReactivateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
+		var user models.User
+		err := userCollection.FindOneAndUpdate(ctx,
+			bson.M{"user_id": userId, "suspended_at": bson.M{"$exists": true}},
+			bson.M{
+				"$unset": bson.M{"suspended_at": "", "suspended_until": "", "suspension_reason": ""},
+				"$set":   bson.M{"updated_at": time.Now()},
+			}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no suspended user with this id"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while reactivating the user"})
+			return
+		}
+
+		helper.RecordAudit(c, helper.AuditAccountReactivated, userId, helper.AuditSuccess, nil)
+		notifyReactivation(user)
+		c.JSON(http.StatusOK, gin.H{"message": "user reactivated"})
+	}
+}
+
+// CreateUser adds a user straight away, already verified, without the signup emails.
+// It is for seed and support accounts, the password policy still applies.
+func CreateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if respondPasswordViolations(c, checkPasswordPolicy(*user.Password, user)) {
+			return
+		}
+
+		phone, err := helper.NormalizePhone(*user.Phone)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		language := ""
+		if user.Language != nil {
+			language = services.MatchLocale(*user.Language)
+		}
+
+		count, err := userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking for the email"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "this email already exists"})
+			return
+		}
+		count, err = userCollection.CountDocuments(ctx, bson.M{"phone": phone})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking for the phone number"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "this phone number already exists"})
+			return
+		}
+
+		hash, err := HashPassword(*user.Password)
+		if err != nil {
+			respondHashError(c, err)
+			return
+		}
+
+		now := time.Now()
+		newUser := models.User{
+			ID:                  primitive.NewObjectID(),
+			First_name:          user.First_name,
+			Last_name:           user.Last_name,
+			Password:            &hash,
+			Email:               user.Email,
+			Phone:               &phone,
+			User_type:           user.User_type,
+			Language:            &language,
+			Created_at:          now,
+			Updated_at:          now,
+			Password_changed_at: now,
+			IsVerified:          true,
+		}
+		newUser.User_id = newUser.ID.Hex()
+		if _, err = userCollection.InsertOne(ctx, newUser); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": errAccountExists.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+		recordPasswordHistory(ctx, newUser.User_id, hash)
+
+		helper.RecordAudit(c, helper.AuditUserCreated, newUser.User_id, helper.AuditSuccess, map[string]interface{}{"user_type": *newUser.User_type})
+		c.JSON(http.StatusCreated, models.NewUserResponse(newUser))
+	}
+}
+
+// ChangeUserRole sets the user type. The sessions of the user are ended, so the role in the
+// tokens can't be used anymore and the next login gets tokens with the new one.
+func ChangeUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			User_type string `json:"user_type" validate:"required,eq=ADMIN|eq=USER"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userId := c.Param("user_id")
+		if userId == c.GetString("uid") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "you can't change your own role"})
+			return
+		}
+
+		var user models.User
+		err := userCollection.FindOneAndUpdate(ctx,
+			bson.M{"user_id": userId},
+			bson.M{"$set": bson.M{"user_type": body.User_type, "updated_at": time.Now()}}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while changing the role"})
+			return
+		}
+
+		if *user.User_type != body.User_type {
+			if err = helper.RevokeUserSessions(ctx, userId, helper.RevokedRoleChanged); err != nil {
+				log.Printf("Failed to revoke the sessions after a role change: %v", err)
+			}
+		}
+		helper.RecordAudit(c, helper.AuditRoleChanged, userId, helper.AuditSuccess, map[string]interface{}{"from": *user.User_type, "to": body.User_type})
+		c.JSON(http.StatusOK, gin.H{"message": "role changed, the user has to login again"})
+	}
+}
+
+// ForcePasswordReset ends the sessions of the user and emails a reset link, the user can't login
+// until the password has been reset with it.
+func ForcePasswordReset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
+		var user models.User
+		err := userCollection.FindOneAndUpdate(ctx,
+			bson.M{"user_id": userId},
+			bson.M{"$set": bson.M{"password_reset_required": true, "updated_at": time.Now()}}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while forcing the password reset"})
+			return
+		}
+
+		if err = helper.RevokeUserSessions(ctx, userId, helper.RevokedPasswordResetForced); err != nil {
+			log.Printf("Failed to revoke the sessions after a forced password reset: %v", err)
+		}
+		helper.RecordAudit(c, helper.AuditPasswordResetForced, userId, helper.AuditSuccess, nil)
+		if err = sendPasswordReset(ctx, user); err != nil {
+			log.Printf("Failed to send the password reset: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send password reset email"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "password reset required, a reset link has been sent to the user"})
+	}
+}
+
+// MarkEmailVerified completes a signup by hand, for the users support has checked some other way.
+// It promotes the pending signup of the email just like the link would, only the expiry is not checked.
+func MarkEmailVerified() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// claimed in one step like in VerifyEmail, so a click on the link at the same time can't also create it.
+		var pending models.PendingVerification
+		err := pendingCollection.FindOneAndDelete(ctx, bson.M{"email": body.Email},
+			options.FindOneAndDelete().SetSort(bson.D{{Key: "created_at", Value: -1}})).Decode(&pending)
+		if err == mongo.ErrNoDocuments {
+			count, countErr := userCollection.CountDocuments(ctx, bson.M{"email": body.Email})
+			if countErr != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the email"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "this email is already verified"})
+				return
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "no signup is waiting for this email"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the email"})
+			return
+		}
+
+		user, err := createVerifiedUser(ctx, pending)
+		if errors.Is(err, errAccountExists) {
+			helper.RecordAudit(c, helper.AuditEmailMarkedVerified, pending.Email, helper.AuditFailure, map[string]interface{}{"reason": "account_exists"})
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			// give the record back, the signup is still waiting.
+			if _, restoreErr := pendingCollection.InsertOne(ctx, pending); restoreErr != nil {
+				log.Printf("Failed to restore verification data: %v", restoreErr)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// the older signups of the same email would only fail on the link now.
+		if _, err = pendingCollection.DeleteMany(ctx, bson.M{"email": pending.Email}); err != nil {
+			log.Printf("Failed to remove duplicate verification data: %v", err)
+		}
+		helper.RecordAudit(c, helper.AuditEmailMarkedVerified, user.User_id, helper.AuditSuccess, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "email marked as verified", "user": models.NewUserResponse(user)})
+	}
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMarkEmailVerified(t *testing.T) {
+	ctx := requireMongo(t)
+	gin.SetMode(gin.TestMode)
+
+	email := "admin-verify-" + uuid.NewString() + "@example.com"
+	cleanupUsers(t, bson.M{"email": email})
+	if _, err := pendingCollection.InsertOne(ctx, testPending(email, testPhone())); err != nil {
+		t.Fatal(err)
+	}
+
+	markVerified := func(email string) int {
+		body, _ := json.Marshal(map[string]string{"email": email})
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/admin/users/verify-email", bytes.NewReader(body))
+		MarkEmailVerified()(c)
+		return w.Code
+	}
+
+	if code := markVerified(email); code != http.StatusOK {
+		t.Fatalf("first call: status = %d, want 200", code)
+	}
+	if count, _ := userCollection.CountDocuments(ctx, bson.M{"email": email}); count != 1 {
+		t.Errorf("%d users with the email, want 1", count)
+	}
+	if count, _ := pendingCollection.CountDocuments(ctx, bson.M{"email": email}); count != 0 {
+		t.Errorf("%d pending signups left, want 0", count)
+	}
+
+	if code := markVerified(email); code != http.StatusConflict {
+		t.Errorf("already verified: status = %d, want 409", code)
+	}
+	if code := markVerified("nobody-" + uuid.NewString() + "@example.com"); code != http.StatusNotFound {
+		t.Errorf("nothing pending: status = %d, want 404", code)
+	}
+}
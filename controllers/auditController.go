@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	helper "jwtauth/helpers"
+	"jwtauth/models"
+)
+
+// auditQuery reads the filters from the query string, from and to are RFC 3339 times.
+func auditQuery(c *gin.Context) (helper.AuditQuery, error) {
+	q := helper.AuditQuery{
+		Action:     c.Query("action"),
+		Outcome:    c.Query("outcome"),
+		Actor_id:   c.Query("actor_id"),
+		Subject:    c.Query("subject"),
+		IP:         c.Query("ip"),
+		Request_id: c.Query("request_id"),
+	}
+	var err error
+	if from := c.Query("from"); from != "" {
+		if q.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return q, err
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if q.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// GetAuditEvents lists the audit log for the admins, filtered and paginated.
+func GetAuditEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		q, err := auditQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be RFC 3339 times"})
+			return
+		}
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 || recordPerPage > 500 {
+			recordPerPage = 50
+		}
+		page, err := strconv.Atoi(c.Query("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		events, total, err := helper.ListAuditEvents(ctx, q, page, recordPerPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing audit events"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"total_count": total, "page": page, "events": events})
+	}
+}
+
+// ExportAuditEvents streams every matching event as JSON lines.
+func ExportAuditEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		q, err := auditQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be RFC 3339 times"})
+			return
+		}
+		cursor, err := helper.AuditEventsCursor(ctx, q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while exporting audit events"})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		helper.RecordAudit(c, helper.AuditAuditLogExported, "", helper.AuditSuccess, map[string]interface{}{"query": c.Request.URL.RawQuery})
+
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="audit-events.jsonl"`)
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		for cursor.Next(ctx) {
+			var event models.AuditEvent
+			if err = cursor.Decode(&event); err != nil {
+				return
+			}
+			if err = encoder.Encode(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jwtauth/database"
+	"jwtauth/models"
+	"jwtauth/services"
+)
+
+var loginAttemptCollection *mongo.Collection = database.OpenCollection(database.Client, "login_attempts")
+
+func lockoutKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginWait says how long the email has to wait before the next login try, 0 means go ahead.
+func loginWait(ctx context.Context, email string) (time.Duration, error) {
+	var attempt models.LoginAttempt
+	err := loginAttemptCollection.FindOne(ctx, bson.M{"email": lockoutKey(email)}).Decode(&attempt)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	if wait := attempt.Locked_until.Sub(now); wait > 0 {
+		return wait, nil
+	}
+	if wait := attempt.Last_failed_at.Add(services.LoginDelay(attempt.Failed_count)).Sub(now); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// recordFailedLogin counts the failure and locks the email once the threshold is hit,
+// it returns true only for the failure that caused the lock. A failure after a pause longer
+// than LOCKOUT_WINDOW starts the count again, so failures spread over months never add up.
+func recordFailedLogin(ctx context.Context, email string) bool {
+	now := time.Now()
+	cutoff := now.Add(-services.LockoutWindow())
+	var attempt models.LoginAttempt
+	err := loginAttemptCollection.FindOneAndUpdate(ctx,
+		bson.M{"email": lockoutKey(email)},
+		// a pipeline update, so the reset and the increment happen in the same atomic step.
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"failed_count": bson.M{"$cond": bson.A{
+				bson.M{"$lt": bson.A{"$last_failed_at", cutoff}},
+				1,
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failed_count", 0}}, 1}},
+			}},
+			"last_failed_at": now,
+		}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		log.Printf("Failed to record the failed login: %v", err)
+		return false
+	}
+	if attempt.Failed_count < services.LockoutThreshold() {
+		return false
+	}
+
+	// the counter starts again after the lock, the filter makes sure only one request locks it.
+	result, err := loginAttemptCollection.UpdateOne(ctx,
+		bson.M{"email": attempt.Email, "failed_count": attempt.Failed_count},
+		bson.M{"$set": bson.M{"failed_count": 0, "locked_until": time.Now().Add(services.LockoutDuration())}},
+	)
+	if err != nil {
+		log.Printf("Failed to lock the account: %v", err)
+		return false
+	}
+	return result.ModifiedCount == 1
+}
+
+// ensureLoginAttemptIndexes keeps one counter per email, concurrent upserts would otherwise split
+// the count, and lets MongoDB remove the counters nobody touched for a while, the emails sprayed
+// at the login included. The ttl is longer than both the counting window and a lock.
+func ensureLoginAttemptIndexes(ctx context.Context) error {
+	if err := removeDuplicateLoginAttempts(ctx); err != nil {
+		return err
+	}
+	ttl := int32((services.LockoutWindow() + services.LockoutDuration()).Seconds())
+	_, err := loginAttemptCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true).SetName("email_unique")},
+		{Keys: bson.D{{Key: "last_failed_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(ttl).SetName("last_failed_at_ttl")},
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 85 {
+		// IndexOptionsConflict: the settings changed since the ttl index was created.
+		err = loginAttemptCollection.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: loginAttemptCollection.Name()},
+			{Key: "index", Value: bson.M{"name": "last_failed_at_ttl", "expireAfterSeconds": ttl}},
+		}).Err()
+	}
+	return err
+}
+
+// removeDuplicateLoginAttempts drops the counters of the emails that have more than one, from before
+// the unique index, they are only counters so they can start again.
+func removeDuplicateLoginAttempts(ctx context.Context) error {
+	cursor, err := loginAttemptCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$email", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var duplicates []struct {
+		Email string `bson:"_id"`
+	}
+	if err = cursor.All(ctx, &duplicates); err != nil {
+		return err
+	}
+	for _, duplicate := range duplicates {
+		if _, err = loginAttemptCollection.DeleteMany(ctx, bson.M{"email": duplicate.Email}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resetFailedLogins(ctx context.Context, email string) {
+	if _, err := loginAttemptCollection.DeleteOne(ctx, bson.M{"email": lockoutKey(email)}); err != nil {
+		log.Printf("Failed to reset the failed logins: %v", err)
+	}
+}
+
+// respondLoginLocked is the same answer for a locked account and for an unknown email.
+func respondLoginLocked(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, please try again later", "retry_after": seconds})
+}
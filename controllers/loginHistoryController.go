@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jwtauth/database"
+	helper "jwtauth/helpers"
+	"jwtauth/models"
+	"jwtauth/services"
+)
+
+var loginHistoryCollection *mongo.Collection = database.OpenCollection(database.Client, "login_history")
+
+// the device cookie is a random id that tells us a browser has been seen before, it lives for two years.
+const deviceCookieName = "device_id"
+const deviceCookieMaxAge = 2 * 365 * 24 * 60 * 60
+
+// the apps that don't keep cookies can send their own install id in this header instead.
+const deviceHeaderName = "X-Device-ID"
+
+// deviceKey tells the devices of a user apart, in this order: the X-Device-ID header of an app,
+// the device cookie of a browser (a new one is set in cookie mode), and for the other clients
+// the user agent with the network the request comes from (/24 for ipv4, /48 for ipv6), so a client
+// that keeps no cookie isn't a new device on every login.
+func deviceKey(c *gin.Context) string {
+	if id := strings.TrimSpace(c.GetHeader(deviceHeaderName)); id != "" && len(id) <= 128 {
+		return "header:" + id
+	}
+	if id, err := c.Cookie(deviceCookieName); err == nil && id != "" {
+		return id
+	}
+	if helper.CookieMode(c) {
+		id := services.GenerateSecureToken()
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(deviceCookieName, id, deviceCookieMaxAge, "/", "", services.SecureCookies(), true)
+		return id
+	}
+	return "agent:" + c.Request.UserAgent() + "|net:" + networkPrefix(c.ClientIP())
+}
+
+// networkPrefix cuts the ip down to the network it comes from, an address that changes within
+// the same network is still the same place.
+func networkPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// recordLogin adds the login to the history of the user, and sends a notice when it comes from
+// a device the user never logged in from. the very first login is not news to anybody.
+// the record is returned even when it couldn't be stored, the session is opened with it.
+func recordLogin(c *gin.Context, ctx context.Context, user models.User) models.LoginRecord {
+	device := helper.ParseUserAgent(c.Request.UserAgent())
+	record := models.LoginRecord{
+		User_id:     user.User_id,
+		Timestamp:   time.Now().UTC(),
+		IP:          c.ClientIP(),
+		User_agent:  c.Request.UserAgent(),
+		Browser:     device.Browser,
+		OS:          device.OS,
+		Device_type: device.Device_type,
+		Device_hash: services.HashToken(deviceKey(c)),
+	}
+	if location, ok := services.LookupIP(record.IP); ok {
+		record.Country = location.Country
+		record.City = location.City
+	}
+
+	var previous models.LoginRecord
+	err := loginHistoryCollection.FindOne(ctx, bson.M{"user_id": user.User_id}).Decode(&previous)
+	hasHistory := err == nil
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Failed to read login history: %v", err)
+		return record
+	}
+	if hasHistory {
+		count, err := loginHistoryCollection.CountDocuments(ctx, bson.M{"user_id": user.User_id, "device_hash": record.Device_hash})
+		if err != nil {
+			log.Printf("Failed to read login history: %v", err)
+			return record
+		}
+		record.New_device = count == 0
+	}
+
+	if _, err := loginHistoryCollection.InsertOne(ctx, record); err != nil {
+		log.Printf("Failed to record login: %v", err)
+		return record
+	}
+	if record.New_device {
+		notifyNewDevice(user, record)
+	}
+	return record
+}
+
+// GetLoginHistory lists the logins of a user, the newest first.
+func GetLoginHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.Param("user_id")
+
+		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 || recordPerPage > 100 {
+			recordPerPage = 20
+		}
+		page, err := strconv.Atoi(c.Query("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		filter := bson.M{"user_id": userId}
+		total, err := loginHistoryCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing logins"})
+			return
+		}
+		cursor, err := loginHistoryCollection.Find(ctx, filter, options.Find().
+			SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+			SetSkip(int64((page-1)*recordPerPage)).
+			SetLimit(int64(recordPerPage)))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing logins"})
+			return
+		}
+		logins := []models.LoginRecord{}
+		if err = cursor.All(ctx, &logins); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing logins"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"total_count": total, "page": page, "logins": logins})
+	}
+}
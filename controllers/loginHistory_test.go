@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func deviceKeyFor(t *testing.T, target string, header http.Header, remoteAddr string) (string, *httptest.ResponseRecorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, target, nil)
+	c.Request.Header = header
+	c.Request.RemoteAddr = remoteAddr
+	return deviceKey(c), w
+}
+
+func TestDeviceKeyHeaderClientIsStable(t *testing.T) {
+	header := http.Header{"User-Agent": {"okhttp/4.12.0"}}
+
+	// a header client without a device id, logging in from another address of the same network.
+	first, w := deviceKeyFor(t, "/users/login", header, "203.0.113.10:5000")
+	second, _ := deviceKeyFor(t, "/users/login", header, "203.0.113.77:6000")
+	if first != second {
+		t.Errorf("device key changed between logins: %q != %q", first, second)
+	}
+	if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("device cookie set outside cookie mode: %q", cookie)
+	}
+
+	other, _ := deviceKeyFor(t, "/users/login", header, "198.51.100.10:5000")
+	if other == first {
+		t.Errorf("another network gave the same device key")
+	}
+}
+
+func TestDeviceKeyPrefersHeaderThenCookie(t *testing.T) {
+	key, _ := deviceKeyFor(t, "/users/login", http.Header{
+		"X-Device-Id": {"install-1"},
+		"Cookie":      {"device_id=browser-1"},
+	}, "203.0.113.10:5000")
+	if key != "header:install-1" {
+		t.Errorf("key = %q, want the X-Device-ID header", key)
+	}
+
+	key, _ = deviceKeyFor(t, "/users/login", http.Header{"Cookie": {"device_id=browser-1"}}, "203.0.113.10:5000")
+	if key != "browser-1" {
+		t.Errorf("key = %q, want the device cookie", key)
+	}
+}
+
+func TestDeviceKeySetsCookieInCookieMode(t *testing.T) {
+	key, w := deviceKeyFor(t, "/users/login?mode=cookie", http.Header{}, "203.0.113.10:5000")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != deviceCookieName || cookies[0].Value != key {
+		t.Errorf("cookies = %v, want a device cookie with %q", cookies, key)
+	}
+}
+
+func TestNetworkPrefix(t *testing.T) {
+	cases := map[string]string{
+		"203.0.113.77":      "203.0.113.0/24",
+		"2001:db8:1:2:3::4": "2001:db8:1::/48",
+		"not an ip":         "not an ip",
+	}
+	for ip, want := range cases {
+		if got := networkPrefix(ip); got != want {
+			t.Errorf("networkPrefix(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
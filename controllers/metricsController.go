@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"expvar"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics shows the expvar counters, like the password_hashing queue depth and latency.
+// The admin route group checks the user type.
+func Metrics() gin.HandlerFunc {
+	handler := expvar.Handler()
+	return func(c *gin.Context) {
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"log"
+	"time"
+
+	"jwtauth/models"
+	"jwtauth/services"
+)
+
+// the security notices sent from the controllers, they all go through the outbox.
+
+// languageOf is the language the user gets the emails in.
+func languageOf(user models.User) string {
+	if user.Language != nil {
+		return *user.Language
+	}
+	return ""
+}
+
+// notifyLockout lets the owner know, only when the account really exists.
+func notifyLockout(user models.User) {
+	emailService := services.NewEmailService()
+	if err := emailService.SendLockoutNotice(*user.Email, *user.First_name, languageOf(user), services.LockoutDuration()); err != nil {
+		log.Printf("Failed to queue lockout notice: %v", err)
+	}
+}
+
+// notifyExistingAccount tells the owner that somebody tried to sign up with their email.
+func notifyExistingAccount(user models.User) {
+	emailService := services.NewEmailService()
+	if err := emailService.SendAccountExistsNotice(*user.Email, *user.First_name, languageOf(user)); err != nil {
+		log.Printf("Failed to queue account exists notice: %v", err)
+	}
+}
+
+// notifyPasswordChanged lets the owner know, in case it wasn't them.
+func notifyPasswordChanged(user models.User) {
+	emailService := services.NewEmailService()
+	if err := emailService.SendPasswordChangedNotice(*user.Email, *user.First_name, languageOf(user)); err != nil {
+		log.Printf("Failed to queue password changed notice: %v", err)
+	}
+}
+
+// notifyNewDevice tells the owner about a login from a device we haven't seen them on.
+func notifyNewDevice(user models.User, login models.LoginRecord) {
+	emailService := services.NewEmailService()
+	if err := emailService.SendNewDeviceNotice(*user.Email, *user.First_name, languageOf(user), login); err != nil {
+		log.Printf("Failed to queue new device notice: %v", err)
+	}
+}
+
+// notifyEmailChange warns the old address that the account is moving to a new one.
+func notifyEmailChange(user models.User, newEmail string) {
+	emailService := services.NewEmailService()
+	if err := emailService.SendEmailChangeNotice(*user.Email, *user.First_name, languageOf(user), newEmail); err != nil {
+		log.Printf("Failed to queue email change notice: %v", err)
+	}
+}
+
+// notifySuspension tells the user their account was suspended, and why.
+func notifySuspension(user models.User, reason string, until *time.Time) {
+	emailService := services.NewEmailService()
+	if err := emailService.SendSuspensionNotice(*user.Email, *user.First_name, languageOf(user), reason, until); err != nil {
+		log.Printf("Failed to queue suspension notice: %v", err)
+	}
+}
+
+func notifyReactivation(user models.User) {
+	emailService := services.NewEmailService()
+	if err := emailService.SendReactivationNotice(*user.Email, *user.First_name, languageOf(user)); err != nil {
+		log.Printf("Failed to queue reactivation notice: %v", err)
+	}
+}
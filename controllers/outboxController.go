@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	helper "jwtauth/helpers"
+	"jwtauth/services"
+)
+
+// GetOutboxMessages lets an admin look at the queued emails, ?status=dead shows the ones that gave up.
+func GetOutboxMessages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 10
+		}
+		page, err := strconv.Atoi(c.Query("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		messages, total, err := services.ListOutbox(ctx, c.Query("status"), page, recordPerPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing outbox messages"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"total_count": total, "messages": messages})
+	}
+}
+
+// RetryOutboxMessage queues a failed message again.
+func RetryOutboxMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		err = services.RetryOutboxMessage(ctx, id)
+		if err == services.ErrOutboxMessageNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while retrying the message"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditOutboxRetried, id.Hex(), helper.AuditSuccess, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "message queued for delivery"})
+	}
+}
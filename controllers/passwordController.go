@@ -0,0 +1,431 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jwtauth/database"
+	helper "jwtauth/helpers"
+	"jwtauth/models"
+	"jwtauth/services"
+)
+
+var passwordResetCollection *mongo.Collection = database.OpenCollection(database.Client, "password_resets")
+
+// the last hashes of every user, the newest one is the current password.
+var passwordHistoryCollection *mongo.Collection = database.OpenCollection(database.Client, "password_history")
+
+// checkPasswordPolicy runs the password policy with the user's own email and names.
+func checkPasswordPolicy(password string, user models.User) []services.PasswordViolation {
+	var personal []string
+	for _, value := range []*string{user.Email, user.First_name, user.Last_name} {
+		if value != nil {
+			personal = append(personal, *value)
+		}
+	}
+	return services.CheckPassword(password, personal...)
+}
+
+// respondPasswordViolations answers 400 with every rule the password breaks.
+func respondPasswordViolations(c *gin.Context, violations []services.PasswordViolation) bool {
+	if len(violations) == 0 {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "password does not meet the password policy", "violations": violations})
+	return true
+}
+
+// passwordReused checks the candidate against the current password and the ones in the history.
+func passwordReused(ctx context.Context, user models.User, candidate string) (bool, error) {
+	size := services.PasswordHistorySize()
+	if size <= 0 {
+		return false, nil
+	}
+
+	var history []models.PasswordHistory
+	cursor, err := passwordHistoryCollection.Find(ctx, bson.M{"user_id": user.User_id},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(size)))
+	if err != nil {
+		return false, err
+	}
+	if err = cursor.All(ctx, &history); err != nil {
+		return false, err
+	}
+
+	hashes := []string{*user.Password}
+	for _, old := range history {
+		if old.Hash != *user.Password {
+			hashes = append(hashes, old.Hash)
+		}
+	}
+	for _, hash := range hashes {
+		same, _, err := VerifyPassword(candidate, hash)
+		if err != nil {
+			return false, err
+		}
+		if same {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// respondInvalidNewPassword checks the policy and the history, and answers 400 when the
+// new password can't be used. It returns true when it answered.
+func respondInvalidNewPassword(c *gin.Context, ctx context.Context, user models.User, newPassword string) bool {
+	violations := checkPasswordPolicy(newPassword, user)
+	if len(violations) == 0 {
+		reused, err := passwordReused(ctx, user, newPassword)
+		if err != nil {
+			respondHashError(c, err)
+			return true
+		}
+		if reused {
+			violations = append(violations, services.PasswordViolation{
+				Rule:    "history",
+				Message: "password must be different from your last " + strconv.Itoa(services.PasswordHistorySize()) + " passwords",
+			})
+		}
+	}
+	return respondPasswordViolations(c, violations)
+}
+
+// passwordExpired is true when the password is older than PASSWORD_MAX_AGE.
+func passwordExpired(user models.User) bool {
+	maxAge := services.PasswordMaxAge()
+	if maxAge <= 0 {
+		return false
+	}
+	changed := user.Password_changed_at
+	if changed.IsZero() {
+		changed = user.Created_at
+	}
+	return !changed.IsZero() && time.Since(changed) > maxAge
+}
+
+// recordPasswordHistory keeps the new hash and drops the ones past the history size.
+func recordPasswordHistory(ctx context.Context, userId string, hash string) {
+	size := services.PasswordHistorySize()
+	if size <= 0 {
+		return
+	}
+	_, err := passwordHistoryCollection.InsertOne(ctx, models.PasswordHistory{User_id: userId, Hash: hash, Created_at: time.Now()})
+	if err != nil {
+		log.Printf("Failed to record the password history: %v", err)
+		return
+	}
+
+	var old []models.PasswordHistory
+	cursor, err := passwordHistoryCollection.Find(ctx, bson.M{"user_id": userId},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetSkip(int64(size)))
+	if err == nil {
+		err = cursor.All(ctx, &old)
+	}
+	if err != nil {
+		log.Printf("Failed to trim the password history: %v", err)
+		return
+	}
+	if len(old) > 0 {
+		_, err = passwordHistoryCollection.DeleteMany(ctx, bson.M{"user_id": userId, "created_at": bson.M{"$lte": old[0].Created_at}})
+		if err != nil {
+			log.Printf("Failed to trim the password history: %v", err)
+		}
+	}
+}
+
+// setPassword stores the new password, it is the last step of both the change and the reset.
+// keepSid is the session that stays logged in, empty ends all of them.
+func setPassword(ctx context.Context, user models.User, newPassword string, keepSid string) error {
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	_, err = userCollection.UpdateOne(ctx,
+		bson.M{"user_id": user.User_id},
+		bson.M{
+			"$set":   bson.M{"password": hash, "updated_at": time.Now(), "password_changed_at": time.Now()},
+			"$unset": bson.M{"password_reset_required": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	recordPasswordHistory(ctx, user.User_id, hash)
+
+	// whoever knew the old password is logged out everywhere, only the session that made
+	// the change stays when the user changed it themselves.
+	if keepSid != "" {
+		err = helper.RevokeOtherSessions(ctx, user.User_id, keepSid, helper.RevokedPasswordChanged)
+	} else {
+		err = helper.RevokeUserSessions(ctx, user.User_id, helper.RevokedPasswordChanged)
+	}
+	if err != nil {
+		log.Printf("Failed to revoke the sessions after a password change: %v", err)
+	}
+
+	resetFailedLogins(ctx, *user.Email)
+	notifyPasswordChanged(user)
+	return nil
+}
+
+// ChangePassword lets the logged in user pick a new password, the current one has to be given too.
+func ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Current_password string `json:"current_password" validate:"required"`
+			New_password     string `json:"new_password" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+			return
+		}
+
+		changePassword(c, ctx, user, body.Current_password, body.New_password)
+	}
+}
+
+// changePassword checks the current password and stores the new one.
+func changePassword(c *gin.Context, ctx context.Context, user models.User, currentPassword string, newPassword string) {
+	valid, _, err := VerifyPassword(currentPassword, *user.Password)
+	if err != nil {
+		respondHashError(c, err)
+		return
+	}
+	if !valid {
+		helper.RecordAudit(c, helper.AuditPasswordChanged, user.User_id, helper.AuditFailure, map[string]interface{}{"reason": "wrong_password"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
+		return
+	}
+
+	if respondInvalidNewPassword(c, ctx, user, newPassword) {
+		return
+	}
+
+	if err = setPassword(ctx, user, newPassword, c.GetString("sid")); err != nil {
+		respondHashError(c, err)
+		return
+	}
+	helper.RecordAudit(c, helper.AuditPasswordChanged, user.User_id, helper.AuditSuccess, map[string]interface{}{"via": "change"})
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully."})
+}
+
+// ChangeExpiredPassword is for the users that Login turned away with password_expired, they have
+// no token yet, so they prove who they are with the email and the current password.
+func ChangeExpiredPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Email            string `json:"email" validate:"required,email"`
+			Current_password string `json:"current_password" validate:"required"`
+			New_password     string `json:"new_password" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// this is a password check like the login, so it counts towards the same lockout.
+		wait, err := loginWait(ctx, body.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while changing the password"})
+			return
+		}
+		if wait > 0 {
+			respondLoginLocked(c, wait)
+			return
+		}
+
+		var user models.User
+		err = userCollection.FindOne(ctx, bson.M{"email": body.Email}).Decode(&user)
+		if err != nil {
+			if err = verifyDummyPassword(body.Current_password); err != nil {
+				respondHashError(c, err)
+				return
+			}
+			recordFailedLogin(ctx, body.Email)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": loginFailedMessage})
+			return
+		}
+
+		valid, _, err := VerifyPassword(body.Current_password, *user.Password)
+		if err != nil {
+			respondHashError(c, err)
+			return
+		}
+		if !valid {
+			if recordFailedLogin(ctx, body.Email) {
+				notifyLockout(user)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": loginFailedMessage})
+			return
+		}
+
+		// a closed or suspended account can't change its way back in here, and a reset forced by an
+		// admin has to go through the emailed link, the current password may be known to others.
+		if reason, response := loginBlocked(user, time.Now()); reason != "" {
+			helper.RecordAudit(c, helper.AuditPasswordChanged, user.User_id, helper.AuditDenied, map[string]interface{}{"via": "change_expired", "reason": reason})
+			c.JSON(http.StatusForbidden, response)
+			return
+		}
+		// without a token this endpoint is only for the passwords Login turned away.
+		if !passwordExpired(user) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "password has not expired, log in and change it from the account", "code": "password_not_expired"})
+			return
+		}
+
+		changePassword(c, ctx, user, body.Current_password, body.New_password)
+	}
+}
+
+// ForgotPassword emails a reset link, the answer is the same whether the email has an account or not.
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		response := gin.H{"message": "If an account exists for this email, a password reset link has been sent."}
+
+		var user models.User
+		err := userCollection.FindOne(ctx, bson.M{"email": body.Email}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while resetting the password"})
+			return
+		}
+
+		if err = sendPasswordReset(ctx, user); err != nil {
+			log.Printf("Failed to send the password reset: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send password reset email"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditPasswordReset, user.User_id, helper.AuditSuccess, nil)
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// sendPasswordReset emails a new reset link to the user, only the newest link works.
+func sendPasswordReset(ctx context.Context, user models.User) error {
+	if _, err := passwordResetCollection.DeleteMany(ctx, bson.M{"user_id": user.User_id}); err != nil {
+		log.Printf("Failed to remove old password resets: %v", err)
+	}
+
+	token := services.GenerateSecureToken()
+	_, err := passwordResetCollection.InsertOne(ctx, models.PasswordReset{
+		User_id:    user.User_id,
+		Token_hash: services.HashToken(token),
+		Expires_at: time.Now().Add(services.PasswordResetTTL()),
+		Created_at: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	emailService := services.NewEmailService()
+	return emailService.SendPasswordResetEmail(*user.Email, *user.First_name, languageOf(user), token)
+}
+
+// ResetPassword sets a new password with the token from the reset email.
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Token        string `json:"token" validate:"required"`
+			New_password string `json:"new_password" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var reset models.PasswordReset
+		err := passwordResetCollection.FindOne(ctx, bson.M{
+			"token_hash": services.HashToken(body.Token),
+			"expires_at": bson.M{"$gt": time.Now()},
+		}).Decode(&reset)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while resetting the password"})
+			return
+		}
+
+		var user models.User
+		if err = userCollection.FindOne(ctx, bson.M{"user_id": reset.User_id}).Decode(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+			return
+		}
+
+		// the token is kept while the new password is rejected, so the user can try another one.
+		if respondInvalidNewPassword(c, ctx, user, body.New_password) {
+			return
+		}
+
+		// the token can be used only once, even by two requests at the same time.
+		err = passwordResetCollection.FindOneAndDelete(ctx, bson.M{"_id": reset.ID}).Err()
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while resetting the password"})
+			return
+		}
+
+		if err = setPassword(ctx, user, body.New_password, ""); err != nil {
+			respondHashError(c, err)
+			return
+		}
+		helper.RecordAudit(c, helper.AuditPasswordChanged, user.User_id, helper.AuditSuccess, map[string]interface{}{"via": "reset"})
+		c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully. You can now login."})
+	}
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"jwtauth/hashing"
+	"jwtauth/models"
+)
+
+func TestLoginBlocked(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	cases := []struct {
+		name string
+		user models.User
+		want string
+	}{
+		{"active", models.User{}, ""},
+		{"deleted", models.User{Deleted_at: &past}, "account_deleted"},
+		{"reset required", models.User{Password_reset_required: true}, "password_reset_required"},
+		{"suspended", models.User{Suspended_at: &past}, "account_suspended"},
+		{"suspended until later", models.User{Suspended_at: &past, Suspended_until: &future}, "account_suspended"},
+		{"suspension over", models.User{Suspended_at: &past, Suspended_until: &past}, ""},
+		{"deleted and suspended", models.User{Deleted_at: &past, Suspended_at: &past}, "account_deleted"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, response := loginBlocked(tc.user, now)
+			if reason != tc.want {
+				t.Fatalf("reason = %q, want %q", reason, tc.want)
+			}
+			if reason != "" && response["code"] != reason {
+				t.Errorf("code = %v, want %q", response["code"], reason)
+			}
+		})
+	}
+}
+
+func TestPasswordExpired(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name    string
+		maxAge  string
+		changed time.Time
+		created time.Time
+		want    bool
+	}{
+		{"no max age", "", now.AddDate(-5, 0, 0), time.Time{}, false},
+		{"changed recently", "720h", now.Add(-24 * time.Hour), time.Time{}, false},
+		{"changed too long ago", "720h", now.Add(-1000 * time.Hour), time.Time{}, true},
+		{"never changed, created recently", "720h", time.Time{}, now.Add(-24 * time.Hour), false},
+		{"never changed, created too long ago", "720h", time.Time{}, now.Add(-1000 * time.Hour), true},
+		{"no dates at all", "720h", time.Time{}, time.Time{}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("PASSWORD_MAX_AGE", tc.maxAge)
+			user := models.User{Password_changed_at: tc.changed, Created_at: tc.created}
+			if got := passwordExpired(user); got != tc.want {
+				t.Errorf("passwordExpired = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestChangeExpiredPassword(t *testing.T) {
+	ctx := requireMongo(t)
+	gin.SetMode(gin.TestMode)
+	t.Setenv("BCRYPT_COST", "4")
+	t.Setenv("PASSWORD_HASH_ALGO", "bcrypt")
+	t.Setenv("PASSWORD_MAX_AGE", "720h")
+	t.Setenv("BREACHED_PASSWORDS_PATH", "")
+
+	const current, next = "Old-pa55word!x", "vK7#pQ2!mZ9x-new"
+	hash, err := hashing.BcryptHasher{Cost: 4}.Hash(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	longAgo := time.Now().Add(-1000 * time.Hour)
+	yesterday := time.Now().Add(-24 * time.Hour)
+
+	cases := []struct {
+		name       string
+		changed    time.Time
+		suspended  bool
+		deleted    bool
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "expired", changed: longAgo, wantStatus: http.StatusOK},
+		{name: "not expired", changed: yesterday, wantStatus: http.StatusForbidden, wantCode: "password_not_expired"},
+		{name: "expired but suspended", changed: longAgo, suspended: true, wantStatus: http.StatusForbidden, wantCode: "account_suspended"},
+		{name: "expired but deleted", changed: longAgo, deleted: true, wantStatus: http.StatusForbidden, wantCode: "account_deleted"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			email := "expired-" + uuid.NewString() + "@example.com"
+			cleanupUsers(t, bson.M{"email": email})
+			t.Cleanup(func() { resetFailedLogins(ctx, email) })
+
+			user := testUser(email, hash)
+			user.Password_changed_at = tc.changed
+			if tc.suspended {
+				user.Suspended_at = &yesterday
+			}
+			if tc.deleted {
+				user.Deleted_at = &yesterday
+			}
+			if _, err := userCollection.InsertOne(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := json.Marshal(map[string]string{"email": email, "current_password": current, "new_password": next})
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/users/password/change-expired", bytes.NewReader(body))
+			ChangeExpiredPassword()(c)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.wantStatus, w.Body)
+			}
+			var response struct {
+				Code string `json:"code"`
+			}
+			_ = json.Unmarshal(w.Body.Bytes(), &response)
+			if response.Code != tc.wantCode {
+				t.Errorf("code = %q, want %q", response.Code, tc.wantCode)
+			}
+
+			var stored models.User
+			if err := userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&stored); err != nil {
+				t.Fatal(err)
+			}
+			changed := *stored.Password != hash
+			if changed != (tc.wantStatus == http.StatusOK) {
+				t.Errorf("password changed = %v with status %d", changed, w.Code)
+			}
+		})
+	}
+}
+
+func testUser(email string, passwordHash string) models.User {
+	firstName, lastName, userType, phone := "Test", "User", "USER", testPhone()
+	user := models.User{
+		ID:         primitive.NewObjectID(),
+		First_name: &firstName,
+		Last_name:  &lastName,
+		Password:   &passwordHash,
+		Email:      &email,
+		Phone:      &phone,
+		User_type:  &userType,
+		Created_at: time.Now(),
+		Updated_at: time.Now(),
+		IsVerified: true,
+	}
+	user.User_id = user.ID.Hex()
+	return user
+}
@@ -0,0 +1,269 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jwtauth/database"
+	helper "jwtauth/helpers"
+	"jwtauth/models"
+	"jwtauth/services"
+)
+
+var phoneVerificationCollection *mongo.Collection = database.OpenCollection(database.Client, "phone_verifications")
+
+// SendPhoneOTP texts a one time code to the phone number of the logged in user.
+func SendPhoneOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.GetString("uid")
+		var user models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+			return
+		}
+		if user.PhoneVerified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "phone number is already verified"})
+			return
+		}
+
+		// one code per user, a new one replaces the old one. The replace is conditional on the record
+		// we read, and a new record can only be inserted once per user, so two requests at the same
+		// time can't both get past the cooldown and the daily cap and send an sms each.
+		now := time.Now()
+		code := services.GenerateVerificationCode()
+		record := models.PhoneVerification{
+			User_id:    userId,
+			Phone:      *user.Phone,
+			Code_hash:  services.HashVerificationCode(*user.Phone, code),
+			Expires_at: now.Add(services.PhoneOTPTTL()),
+			Created_at: now,
+			Sent_day:   services.SendDay(now),
+			Sent_count: 1,
+		}
+
+		var previous models.PhoneVerification
+		err := phoneVerificationCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&previous)
+		switch {
+		case err == nil:
+			if wait, ok := services.CheckPhoneOTPAllowed(previous.Created_at, previous.Sent_day, previous.Sent_count, now); !ok {
+				respondPhoneOTPLimited(c, wait)
+				return
+			}
+			if previous.Sent_day == record.Sent_day {
+				record.Sent_count = previous.Sent_count + 1
+			}
+			var result *mongo.UpdateResult
+			result, err = phoneVerificationCollection.ReplaceOne(ctx,
+				bson.M{"user_id": userId, "created_at": previous.Created_at}, record)
+			if err == nil && result.MatchedCount == 0 {
+				// another request sent a code in the meantime.
+				respondPhoneOTPLimited(c, services.PhoneOTPCooldown())
+				return
+			}
+		case err == mongo.ErrNoDocuments:
+			_, err = phoneVerificationCollection.InsertOne(ctx, record)
+			if mongo.IsDuplicateKeyError(err) {
+				respondPhoneOTPLimited(c, services.PhoneOTPCooldown())
+				return
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while sending the code"})
+			return
+		}
+
+		if err = services.SendPhoneOTP(ctx, *user.Phone, code); err != nil {
+			log.Printf("Failed to send phone verification code: %v", err)
+			// the code never went out, it doesn't count against the cooldown or the cap.
+			if record.Sent_count > 1 {
+				phoneVerificationCollection.UpdateOne(ctx,
+					bson.M{"user_id": userId, "created_at": record.Created_at},
+					bson.M{"$set": bson.M{"code_hash": "", "created_at": previous.Created_at, "sent_count": record.Sent_count - 1}})
+			} else {
+				phoneVerificationCollection.DeleteOne(ctx, bson.M{"user_id": userId, "created_at": record.Created_at})
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the verification code"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Verification code sent to " + *user.Phone})
+	}
+}
+
+// respondPhoneOTPLimited writes the 429 with a Retry-After header.
+func respondPhoneOTPLimited(c *gin.Context, wait time.Duration) {
+	seconds := int(wait.Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "please wait before requesting another code", "retry_after": seconds})
+}
+
+// ensurePhoneVerificationIndexes keeps one code per user, even when two requests insert the first one at the same time.
+func ensurePhoneVerificationIndexes(ctx context.Context) error {
+	_, err := phoneVerificationCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("user_id_unique"),
+	})
+	return err
+}
+
+// ConfirmPhoneOTP checks the code and marks the phone number as verified.
+func ConfirmPhoneOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Code string `json:"code" validate:"required,len=6,numeric"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userId := c.GetString("uid")
+		var record models.PhoneVerification
+		err := phoneVerificationCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&record)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verification code"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the code"})
+			return
+		}
+
+		maxAttempts := services.PhoneOTPMaxAttempts()
+		if record.Attempts >= maxAttempts {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many wrong codes, please request a new one"})
+			return
+		}
+		if time.Now().After(record.Expires_at) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "verification code has expired"})
+			return
+		}
+
+		// the attempt is reserved before the code is compared, the same as for the email codes.
+		err = phoneVerificationCollection.FindOneAndUpdate(ctx,
+			bson.M{"_id": record.ID, "code_hash": record.Code_hash, "attempts": bson.M{"$lt": maxAttempts}},
+			bson.M{"$inc": bson.M{"attempts": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&record)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many wrong codes, please request a new one"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the code"})
+			return
+		}
+
+		if !services.VerificationCodeMatches(record.Code_hash, record.Phone, body.Code) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":              "invalid verification code",
+				"attempts_remaining": maxAttempts - record.Attempts,
+			})
+			return
+		}
+
+		// the code can be used only once.
+		err = phoneVerificationCollection.FindOneAndDelete(ctx, bson.M{
+			"_id":       record.ID,
+			"code_hash": record.Code_hash,
+		}).Err()
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verification code"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the code"})
+			return
+		}
+
+		// only the number the code was sent to gets verified, in case it was changed in the meantime.
+		result, err := userCollection.UpdateOne(ctx,
+			bson.M{"user_id": userId, "phone": record.Phone},
+			bson.M{"$set": bson.M{"phone_verified": true, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the code"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "phone number has changed, please request a new code"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditPhoneVerified, userId, helper.AuditSuccess, map[string]interface{}{"phone": record.Phone})
+		c.JSON(http.StatusOK, gin.H{"message": "Phone number verified successfully."})
+	}
+}
+
+// NormalizeStoredPhones rewrites the phone numbers that were stored before the signup kept them
+// in E.164, otherwise the uniqueness checks, which compare the normalized form, miss them.
+// A number that can't be normalized, or whose normalized form another user already has, is
+// only logged and left for an admin. It runs at startup, before the unique indexes are created.
+func NormalizeStoredPhones(ctx context.Context) error {
+	e164 := bson.M{"phone": bson.M{"$not": primitive.Regex{Pattern: `^\+[1-9][0-9]{7,14}$`}}}
+	for _, collection := range []*mongo.Collection{userCollection, pendingCollection} {
+		cursor, err := collection.Find(ctx, e164, options.Find().SetProjection(bson.M{"_id": 1, "phone": 1}))
+		if err != nil {
+			return err
+		}
+		var records []struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Phone string             `bson:"phone"`
+		}
+		if err = cursor.All(ctx, &records); err != nil {
+			return err
+		}
+
+		normalized := 0
+		for _, record := range records {
+			phone, err := helper.NormalizePhone(record.Phone)
+			if err != nil {
+				log.Printf("Can't normalize the phone number of %s %s: %v", collection.Name(), record.ID.Hex(), err)
+				continue
+			}
+			if collection == userCollection {
+				count, err := userCollection.CountDocuments(ctx, bson.M{"phone": phone, "_id": bson.M{"$ne": record.ID}})
+				if err != nil {
+					return err
+				}
+				if count > 0 {
+					log.Printf("The phone number of user %s is already used by another user as %s", record.ID.Hex(), phone)
+					continue
+				}
+			}
+			_, err = collection.UpdateOne(ctx,
+				bson.M{"_id": record.ID, "phone": record.Phone},
+				bson.M{"$set": bson.M{"phone": phone}},
+			)
+			if mongo.IsDuplicateKeyError(err) {
+				log.Printf("The phone number of user %s is already used by another user as %s", record.ID.Hex(), phone)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			normalized++
+		}
+		if normalized > 0 {
+			log.Printf("Normalized %d phone numbers in %s", normalized, collection.Name())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jwtauth/database"
+	helper "jwtauth/helpers"
+	"jwtauth/models"
+	"jwtauth/services"
+)
+
+var emailChangeCollection *mongo.Collection = database.OpenCollection(database.Client, "email_changes")
+
+// the fields of models.User that can be changed through UpdateUser.
+var updatableUserFields = []string{"First_name", "Last_name", "Phone", "Email", "Language"}
+
+// UpdateUser changes the profile of a user. Only the fields in the body are changed, and they are
+// validated with the tags of models.User. A new phone has to be verified again, and a new email
+// takes effect only after the link sent to it is opened.
+func UpdateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.Param("user_id")
+
+		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var input models.User
+		if err := c.BindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if input.Password != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the password is changed through /users/me/password"})
+			return
+		}
+		if input.User_type != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the user type can't be changed here"})
+			return
+		}
+
+		provided := map[string]*string{
+			"First_name": input.First_name,
+			"Last_name":  input.Last_name,
+			"Phone":      input.Phone,
+			"Email":      input.Email,
+			"Language":   input.Language,
+		}
+		var fields []string
+		for _, field := range updatableUserFields {
+			if provided[field] != nil {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+			return
+		}
+		if err := validate.StructPartial(input, fields...); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		set := bson.M{}
+		if input.First_name != nil {
+			set["first_name"] = *input.First_name
+		}
+		if input.Last_name != nil {
+			set["last_name"] = *input.Last_name
+		}
+		if input.Language != nil {
+			set["language"] = services.MatchLocale(*input.Language)
+		}
+
+		if input.Phone != nil {
+			phone, err := helper.NormalizePhone(*input.Phone)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if user.Phone == nil || phone != *user.Phone {
+				count, err := userCollection.CountDocuments(ctx, bson.M{"phone": phone, "user_id": bson.M{"$ne": user.User_id}})
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking for the phone number"})
+					return
+				}
+				if count > 0 {
+					c.JSON(http.StatusConflict, gin.H{"error": "this phone number already exists"})
+					return
+				}
+				// the new number hasn't been proven yet.
+				set["phone"] = phone
+				set["phone_verified"] = false
+			}
+		}
+
+		newEmail := ""
+		if input.Email != nil && (user.Email == nil || *input.Email != *user.Email) {
+			// changing the email hands over the account, it needs a recent login like the password.
+			if !helper.RecentlyAuthenticated(c, services.StepUpMaxAge()) {
+				helper.RespondStepUpRequired(c, services.StepUpMaxAge())
+				return
+			}
+			count, err := userCollection.CountDocuments(ctx, bson.M{"email": *input.Email})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking for the email"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "this email already exists"})
+				return
+			}
+			newEmail = *input.Email
+		}
+
+		if len(set) > 0 {
+			set["updated_at"] = time.Now()
+			if _, err := userCollection.UpdateOne(ctx, bson.M{"user_id": user.User_id}, bson.M{"$set": set}); err != nil {
+				if mongo.IsDuplicateKeyError(err) {
+					c.JSON(http.StatusConflict, gin.H{"error": "this phone number already exists"})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while updating the user"})
+				return
+			}
+			helper.RecordAudit(c, helper.AuditProfileUpdated, user.User_id, helper.AuditSuccess, map[string]interface{}{"fields": fields})
+		}
+
+		if newEmail != "" {
+			if err := requestEmailChange(ctx, user, newEmail); err != nil {
+				log.Printf("Failed to request the email change: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the confirmation email"})
+				return
+			}
+			helper.RecordAudit(c, helper.AuditEmailChangeRequested, user.User_id, helper.AuditSuccess, nil)
+		}
+
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": user.User_id}).Decode(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+			return
+		}
+		response := gin.H{"user": models.NewUserResponse(user), "email_change_pending": newEmail != ""}
+		if newEmail != "" {
+			response["message"] = "A confirmation link has been sent to the new email, the current one is kept until it is opened."
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// requestEmailChange replaces any earlier request with a new one, sends the link to the new address
+// and lets the old address know.
+func requestEmailChange(ctx context.Context, user models.User, newEmail string) error {
+	if _, err := emailChangeCollection.DeleteMany(ctx, bson.M{"user_id": user.User_id}); err != nil {
+		return err
+	}
+
+	token := services.GenerateSecureToken()
+	change := models.EmailChange{
+		User_id:    user.User_id,
+		Old_email:  *user.Email,
+		New_email:  newEmail,
+		Token_hash: services.HashToken(token),
+		Expires_at: time.Now().Add(services.EmailChangeTTL()),
+		Created_at: time.Now(),
+	}
+	if _, err := emailChangeCollection.InsertOne(ctx, change); err != nil {
+		return err
+	}
+
+	emailService := services.NewEmailService()
+	if err := emailService.SendEmailChangeVerification(newEmail, *user.First_name, languageOf(user), token); err != nil {
+		emailChangeCollection.DeleteOne(ctx, bson.M{"token_hash": change.Token_hash})
+		return err
+	}
+	notifyEmailChange(user, newEmail)
+	return nil
+}
+
+// ConfirmEmailChange is the link sent to the new address, it swaps the email of the user.
+func ConfirmEmailChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "confirmation token is required"})
+			return
+		}
+
+		// the link can be used only once, even by two requests at the same time.
+		var change models.EmailChange
+		err := emailChangeCollection.FindOneAndDelete(ctx, bson.M{
+			"token_hash": services.HashToken(token),
+			"expires_at": bson.M{"$gt": time.Now()},
+		}).Decode(&change)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired confirmation token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while changing the email"})
+			return
+		}
+
+		// somebody may have signed up with the address in the meantime.
+		count, err := userCollection.CountDocuments(ctx, bson.M{"email": change.New_email})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking for the email"})
+			return
+		}
+		if count > 0 {
+			helper.RecordAudit(c, helper.AuditEmailChanged, change.User_id, helper.AuditFailure, map[string]interface{}{"reason": "email_exists"})
+			c.JSON(http.StatusConflict, gin.H{"error": "this email already exists"})
+			return
+		}
+
+		result, err := userCollection.UpdateOne(ctx,
+			bson.M{"user_id": change.User_id, "email": change.Old_email},
+			bson.M{"$set": bson.M{"email": change.New_email, "updated_at": time.Now()}})
+		if mongo.IsDuplicateKeyError(err) {
+			helper.RecordAudit(c, helper.AuditEmailChanged, change.User_id, helper.AuditFailure, map[string]interface{}{"reason": "email_exists"})
+			c.JSON(http.StatusConflict, gin.H{"error": "this email already exists"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while changing the email"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired confirmation token"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditEmailChanged, change.User_id, helper.AuditSuccess, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Email changed successfully. Use the new email to login."})
+	}
+}
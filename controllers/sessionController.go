@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+
+	helper "jwtauth/helpers"
+	"jwtauth/models"
+)
+
+// startSession opens the session for a login on the device of the login record, and hands out its tokens.
+func startSession(ctx context.Context, user models.User, login models.LoginRecord) (token string, refreshToken string, err error) {
+	session, refreshId, err := helper.StartSession(ctx, models.Session{
+		User_id:     user.User_id,
+		User_type:   *user.User_type,
+		Acr:         helper.AcrPassword,
+		IP:          login.IP,
+		User_agent:  login.User_agent,
+		Browser:     login.Browser,
+		OS:          login.OS,
+		Device_type: login.Device_type,
+		Country:     login.Country,
+		City:        login.City,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return helper.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id, session, refreshId)
+}
+
+// RefreshToken trades a refresh token for a new pair, the old refresh token stops working.
+// The cookie clients send no body, the token comes from the cookie and the new ones go back in cookies.
+func RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request struct {
+			Refresh_token string `json:"refresh_token"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&request); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		fromCookie := false
+		if request.Refresh_token == "" {
+			request.Refresh_token, _ = c.Cookie(helper.RefreshTokenCookie)
+			fromCookie = request.Refresh_token != ""
+		}
+		if request.Refresh_token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+			return
+		}
+
+		claims, msg := helper.ValidateToken(request.Refresh_token)
+		if msg != "" {
+			helper.RecordAudit(c, helper.AuditTokenRefreshed, "", helper.AuditDenied, map[string]interface{}{"reason": msg})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+		if claims.Token_type != helper.RefreshToken || claims.Sid == "" {
+			helper.RecordAudit(c, helper.AuditTokenRefreshed, claims.Uid, helper.AuditDenied, map[string]interface{}{"reason": "not a refresh token"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not a refresh token, please login again"})
+			return
+		}
+
+		session, refreshId, err := helper.RotateRefreshToken(ctx, claims.Sid, claims.Uid, claims.Id)
+		switch err {
+		case nil:
+		case helper.ErrRefreshReused:
+			if fromCookie {
+				helper.ClearSessionCookies(c)
+			}
+			helper.RecordAudit(c, helper.AuditSessionRevoked, claims.Uid, helper.AuditSuccess, map[string]interface{}{"sid": claims.Sid, "reason": helper.RevokedRefreshReuse})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has already been used, the session has been ended"})
+			return
+		case helper.ErrSessionRevoked:
+			if fromCookie {
+				helper.ClearSessionCookies(c)
+			}
+			helper.RecordAudit(c, helper.AuditTokenRefreshed, claims.Uid, helper.AuditDenied, map[string]interface{}{"sid": claims.Sid, "reason": "session revoked"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while refreshing the token"})
+			return
+		}
+
+		// the names and the role are read again, they may have changed since the login.
+		var user models.User
+		if err = userCollection.FindOne(ctx, bson.M{"user_id": claims.Uid}).Decode(&user); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		token, refreshToken, err := helper.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id, session, refreshId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while refreshing the token"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditTokenRefreshed, user.User_id, helper.AuditSuccess, map[string]interface{}{"sid": session.ID})
+		if fromCookie {
+			helper.SetSessionCookies(c, token, refreshToken)
+			c.JSON(http.StatusOK, gin.H{"message": "tokens refreshed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
+
+// GetSessions lists the sessions of the caller, marking the one the request came with.
+func GetSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		sessions, err := helper.ListSessions(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing sessions"})
+			return
+		}
+
+		type sessionItem struct {
+			models.Session
+			Current bool `json:"current"`
+		}
+		items := make([]sessionItem, 0, len(sessions))
+		for _, session := range sessions {
+			items = append(items, sessionItem{Session: session, Current: session.ID == c.GetString("sid")})
+		}
+		c.JSON(http.StatusOK, gin.H{"sessions": items})
+	}
+}
+
+// RevokeSession ends one session of the caller, it can be the current one, which is a logout.
+func RevokeSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		sid := c.Param("id")
+		err := helper.RevokeSession(ctx, sid, c.GetString("uid"), helper.RevokedByUser)
+		if err == helper.ErrSessionNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while revoking the session"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditSessionRevoked, c.GetString("uid"), helper.AuditSuccess, map[string]interface{}{"sid": sid, "reason": helper.RevokedByUser})
+		// revoking the current session is the logout of the cookie clients.
+		if sid == c.GetString("sid") {
+			helper.ClearSessionCookies(c)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+	}
+}
+
+// Reauthenticate asks for the password again, for the routes behind RequireRecentAuth.
+// Wrong passwords count towards the lockout like at the login.
+func Reauthenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Password string `json:"password" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+			return
+		}
+
+		wait, err := loginWait(ctx, *user.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the password"})
+			return
+		}
+		if wait > 0 {
+			helper.RecordAudit(c, helper.AuditReauthenticated, user.User_id, helper.AuditDenied, map[string]interface{}{"reason": "locked"})
+			respondLoginLocked(c, wait)
+			return
+		}
+
+		valid, _, err := VerifyPassword(body.Password, *user.Password)
+		if err != nil {
+			respondHashError(c, err)
+			return
+		}
+		if !valid {
+			helper.RecordAudit(c, helper.AuditReauthenticated, user.User_id, helper.AuditFailure, map[string]interface{}{"reason": "wrong_password"})
+			if recordFailedLogin(ctx, *user.Email) {
+				helper.RecordAudit(c, helper.AuditLockout, user.User_id, helper.AuditSuccess, nil)
+				notifyLockout(user)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "password is incorrect"})
+			return
+		}
+		resetFailedLogins(ctx, *user.Email)
+
+		session, refreshId, err := helper.Reauthenticate(ctx, c.GetString("sid"), user.User_id, helper.AcrPassword)
+		if err == helper.ErrSessionRevoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while reauthenticating"})
+			return
+		}
+		token, refreshToken, err := helper.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id, session, refreshId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while reauthenticating"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditReauthenticated, user.User_id, helper.AuditSuccess, map[string]interface{}{"sid": session.ID, "acr": session.Acr})
+
+		if c.GetBool("cookie_auth") {
+			helper.SetSessionCookies(c, token, refreshToken)
+			c.JSON(http.StatusOK, gin.H{"message": "reauthenticated"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
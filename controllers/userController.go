@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,18 +19,49 @@ import (
 	"jwtauth/models"
 	"jwtauth/services"
 
-	"golang.org/x/crypto/bcrypt"
-
 	// it is use to securely store and validate the password.
+	"jwtauth/hashing"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 //to call the instance of collection to fetch the information
 var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 
+//signups that are waiting for the email verification.
+var pendingCollection *mongo.Collection = database.OpenCollection(database.Client, "pending_verifications")
+
+// errAccountExists is the answer when the unique indexes on the email or the phone turn a write down.
+var errAccountExists = errors.New("this email or phone number already exists")
+
+// EnsureIndexes creates the indexes the controllers rely on, it runs at startup.
+func EnsureIndexes(ctx context.Context) error {
+	for _, ensure := range []func(context.Context) error{
+		EnsureUserIndexes,
+		ensurePhoneVerificationIndexes,
+		ensureLoginAttemptIndexes,
+		ensureSignupNoticeIndexes,
+	} {
+		if err := ensure(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureUserIndexes makes the email and the phone unique in the user collection, the checks before
+// each insert can't do it alone when two requests come at the same time.
+func EnsureUserIndexes(ctx context.Context) error {
+	_, err := userCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true).SetName("email_unique")},
+		{Keys: bson.D{{Key: "phone", Value: 1}}, Options: options.Index().SetUnique(true).SetName("phone_unique")},
+	})
+	return err
+}
+
 //To validate struct fields easily.
 //To ensure that incoming data meets the expected format or constraints (e.g., email, required, length).
 var validate = validator.New()
@@ -36,27 +69,126 @@ var validate = validator.New()
 // in the database you can't store the password as it is,
 // you have to hash it before storing, beacuse if not then any one,
 // who has access to the database can get your password.
-func HashPassword(password string) string{
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	if err!=nil{
-		log.Panic(err)
-	}
-	return string(bytes)
+// the hashing runs on a bounded pool, so it fails with hashing.ErrBusy when the server is overloaded.
+func HashPassword(password string) (string, error){
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return hashing.HashPassword(ctx, password)
 }
 
-func VerifyPassword(userPassword string, providedPassword string)(bool, string){
+func VerifyPassword(userPassword string, providedPassword string)(bool, string, error){
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
 	/*It compares a hashed password (typically stored securely in a database) with a plain-text password,
 	 by rehashing the plain-text password and checking if the hashes match.*/
-	err := bcrypt.CompareHashAndPassword([]byte(providedPassword), []byte(userPassword))
-	check := true
+	check, err := hashing.ComparePassword(ctx, providedPassword, userPassword)
+	if err != nil {
+		return false, "", err
+	}
 	msg := ""
 
-	if err!= nil {
-		msg = "email of password is incorrect"
-		check=false
+	if !check {
+		msg = loginFailedMessage
+	}
+	return check, msg, nil
+}
+
+// rehashPassword stores a new hash of the password, only if nobody changed it in the meantime.
+func rehashPassword(ctx context.Context, user models.User, password string) {
+	newHash, err := HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash the password: %v", err)
+		return
+	}
+	_, err = userCollection.UpdateOne(ctx,
+		bson.M{"user_id": user.User_id, "password": *user.Password},
+		bson.M{"$set": bson.M{"password": newHash}},
+	)
+	if err != nil {
+		log.Printf("Failed to store the rehashed password: %v", err)
+	}
+}
+
+// respondHashError turns an overloaded hashing pool into a fast 503.
+func respondHashError(c *gin.Context, err error) {
+	if err == hashing.ErrBusy {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the password"})
+}
+
+// loginBlocked is the reason and the 403 body when the account can't log in even with the right
+// password, or "" when it can. ChangeExpiredPassword gives the same answers as Login.
+func loginBlocked(user models.User, now time.Time) (string, gin.H) {
+	switch {
+	// a closed account only opens again through the restore link.
+	case user.Deleted_at != nil:
+		return "account_deleted", gin.H{"error": "this account has been closed, use the link sent by email to restore it", "code": "account_deleted"}
+	case user.Password_reset_required:
+		return "password_reset_required", gin.H{"error": "a password reset is required, use the link sent by email", "code": "password_reset_required"}
+	case user.IsSuspended(now):
+		response := gin.H{"error": "this account has been suspended", "code": "account_suspended"}
+		if user.Suspended_until != nil {
+			response["suspended_until"] = *user.Suspended_until
+		}
+		return "account_suspended", response
 	}
-	return check, msg
+	return "", nil
+}
+
+// the same answer for an unknown email and a wrong password, so a login can't tell them apart.
+const loginFailedMessage = "email or password is incorrect"
+
+var (
+	dummyPasswordHash string
+	dummyPasswordMu   sync.Mutex
+)
+
+// PrepareDummyPassword hashes the dummy password at startup, so the first unknown email doesn't pay
+// for it. It uses the hasher of PASSWORD_HASH_ALGO, which stays bcrypt until the stored hashes are
+// migrated, see hashing.Current.
+func PrepareDummyPassword(ctx context.Context) error {
+	dummyPasswordMu.Lock()
+	defer dummyPasswordMu.Unlock()
+	if dummyPasswordHash != "" {
+		return nil
+	}
+	hash, err := hashing.HashWith(ctx, hashing.Current(), "dummy-password-for-unknown-emails")
+	if err != nil {
+		return err
+	}
+	dummyPasswordHash = hash
+	return nil
+}
+
+// verifyDummyPassword burns the same hashing time as a real check, it is used when the email
+// has no account, otherwise the fast answer would tell that the email doesn't exist.
+func verifyDummyPassword(password string) error {
+	// only when it couldn't be done at startup.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err := PrepareDummyPassword(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	dummyPasswordMu.Lock()
+	hash := dummyPasswordHash
+	dummyPasswordMu.Unlock()
+
+	_, _, err = VerifyPassword(password, hash)
+	return err
+}
+
+// signupAccepted is the only answer Signup gives once the input is valid, when
+// SIGNUP_CONCEAL_EXISTING is on it is also given for an email or phone that is already taken.
+func signupAccepted(c *gin.Context, mode string) {
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Verification email sent. Please verify your email to complete registration.",
+		"verification_mode": mode,
+	})
 }
 
 func Signup() gin.HandlerFunc {
@@ -76,70 +208,149 @@ func Signup() gin.HandlerFunc {
 			return
 		}
 
-		// Check if email already exists
-		count, err := userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
-		defer cancel()
+		if respondPasswordViolations(c, checkPasswordPolicy(*user.Password, user)) {
+			return
+		}
+
+		// phone numbers are kept in E.164, so the same number typed differently is still the same number.
+		phone, err := helper.NormalizePhone(*user.Phone)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user.Phone = &phone
+
+		// the emails are sent in the language the user asked for, or the one the browser prefers.
+		language := c.GetHeader("Accept-Language")
+		if user.Language != nil && *user.Language != "" {
+			language = *user.Language
+		}
+		language = services.MatchLocale(language)
+
+		// the signup is verified by an emailed link, or by a 6 digit code for the mobile clients.
+		mode := services.VerificationMode(c.Query("verification_mode"))
 
-		if count > 0 {
+		// with SIGNUP_CONCEAL_EXISTING an existing email or phone gets the same answer as a new one,
+		// the owner is told by email instead, and the password is still hashed to take the same time.
+		conceal := services.ConcealExistingAccounts()
+
+		// Check if email already exists
+		var existing models.User
+		err = userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&existing)
+		if err == nil {
+			helper.RecordAudit(c, helper.AuditSignup, *user.Email, helper.AuditDenied, map[string]interface{}{"reason": "email_exists"})
+			if conceal {
+				if _, err = HashPassword(*user.Password); err != nil {
+					respondHashError(c, err)
+					return
+				}
+				// the notice has the same limits as the verification emails, but the answer
+				// stays the same when it is held back, a 429 would tell the two apart.
+				allowed, err := reserveSignupNotice(ctx, *existing.Email)
+				if err != nil {
+					log.Printf("Failed to reserve the account exists notice: %v", err)
+				}
+				if allowed {
+					notifyExistingAccount(existing)
+				}
+				signupAccepted(c, mode)
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email already exists"})
 			return
 		}
 
-		if err != nil {
-			log.Panic(err)
+		if err != mongo.ErrNoDocuments {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking for the email"})
 			return
 		}
 
 		// Check if phone already exists
-		count, err = userCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
+		count, err := userCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
 		defer cancel()
 		if err != nil {
-			log.Panic(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking for the phone number"})
 			return
 		}
 
-		if count > 0 {
+		// in conceal mode a phone in use goes on like any signup, it gets the verification email and
+		// createVerifiedUser turns it down once the email is verified. Any other email sent here would
+		// tell whoever owns the inbox that the phone number is registered.
+		if count > 0 && !conceal {
+			helper.RecordAudit(c, helper.AuditSignup, *user.Email, helper.AuditDenied, map[string]interface{}{"reason": "phone_exists"})
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "this phone number already exists"})
 			return
 		}
 
-		// Generate verification token
-		verifyToken := services.GenerateVerificationToken()
-		
-		// Send verification email first
-		emailService := services.NewEmailService()
-		err = emailService.SendVerificationEmail(*user.Email, verifyToken)
+		hashedPassword, err := HashPassword(*user.Password)
 		if err != nil {
-			log.Printf("Failed to send verification email: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			respondHashError(c, err)
 			return
 		}
 
+		secret, verifyToken, code := newVerificationSecret(*user.Email, mode)
+
 		// Store verification data in temporary collection
-		verificationData := bson.M{
-			"email": *user.Email,
-			"first_name": *user.First_name,
-			"last_name": *user.Last_name,
-			"password": HashPassword(*user.Password),
-			"phone": *user.Phone,
-			"user_type": *user.User_type,
-			"verify_token": verifyToken,
-			"verify_expires": services.GetVerificationExpiryTime(),
-			"created_at": time.Now(),
-		}
-
-		// Store in temporary collection
-		_, err = database.OpenCollection(database.Client, "pending_verifications").InsertOne(ctx, verificationData)
+		pending := models.PendingVerification{
+			First_name:     *user.First_name,
+			Last_name:      *user.Last_name,
+			Password:       hashedPassword,
+			Email:          *user.Email,
+			Phone:          *user.Phone,
+			User_type:      *user.User_type,
+			Language:       language,
+			Verify_token:   verifyToken,
+			Verify_expires: secret["verify_expires"].(time.Time),
+			Verify_mode:    mode,
+			Code_hash:      secret["code_hash"].(string),
+			Created_at:     time.Now(),
+		}
+
+		// signing up again with the same email is only a resend, it goes through the same cooldown and
+		// daily cap as the resend endpoint. The password and the names of the first signup are kept,
+		// otherwise anybody could replace them on a signup that is waiting for its owner to verify.
+		inserted := false
+		stored, err := reservePendingSend(ctx, pending.Email, secret)
+		if err == nil {
+			pending = stored
+		}
+		if err == mongo.ErrNoDocuments {
+			pending.Last_sent_at = time.Now()
+			pending.Sent_day = services.SendDay(pending.Last_sent_at)
+			pending.Sent_count = 1
+			_, err = pendingCollection.InsertOne(ctx, pending)
+			inserted = true
+		}
+		var limited *errSendLimited
+		if conceal && errors.As(err, &limited) {
+			// a 429 here and a 200 for an existing account would tell the two apart.
+			signupAccepted(c, mode)
+			return
+		}
+		if respondSendLimited(c, err) {
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store verification data"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Verification email sent. Please verify your email to complete registration.",
-		})
+		// the email only goes into the outbox here, so a slow smtp server can't fail the signup.
+		// if even that fails a new pending record is removed again, we never keep one without the other.
+		err = sendVerification(pending.Email, pending.First_name, pending.Language, mode, verifyToken, code)
+		if err != nil {
+			log.Printf("Failed to queue verification email: %v", err)
+			if inserted {
+				if _, delErr := pendingCollection.DeleteOne(ctx, bson.M{"verify_token": verifyToken}); delErr != nil {
+					log.Printf("Failed to remove verification data: %v", delErr)
+				}
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+
+		helper.RecordAudit(c, helper.AuditSignup, *user.Email, helper.AuditSuccess, map[string]interface{}{"verification_mode": mode})
+		signupAccepted(c, mode)
 	}
 }
 
@@ -148,65 +359,104 @@ func VerifyEmail() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		token := c.Query("token")
-		if token == "" {
+		verifyToken := c.Query("token")
+		if verifyToken == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "verification token is required"})
 			return
 		}
 
-		// Find verification data
-		var verificationData bson.M
-		err := database.OpenCollection(database.Client, "pending_verifications").FindOne(ctx, bson.M{"verify_token": token}).Decode(&verificationData)
-		if err != nil {
+		// FindOneAndDelete claims the pending record in a single atomic step,
+		// so if the link is clicked twice only one of the requests gets the record.
+		var pending models.PendingVerification
+		err := pendingCollection.FindOneAndDelete(ctx, bson.M{"verify_token": verifyToken}).Decode(&pending)
+		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verification token"})
 			return
 		}
-
-		// Check if token has expired
-		verifyExpires := verificationData["verify_expires"].(time.Time)
-		if time.Now().After(verifyExpires) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "verification token has expired"})
-			return
-		}
-
-		// Create new user
-		user := models.User{
-			ID: primitive.NewObjectID(),
-			First_name: verificationData["first_name"].(*string),
-			Last_name: verificationData["last_name"].(*string),
-			Password: verificationData["password"].(*string),
-			Email: verificationData["email"].(*string),
-			Phone: verificationData["phone"].(*string),
-			User_type: verificationData["user_type"].(*string),
-			Created_at: time.Now(),
-			Updated_at: time.Now(),
-			User_id: primitive.NewObjectID().Hex(),
-			IsVerified: true,
-		}
-
-		// Generate tokens
-		token, refreshToken, _ := helper.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id)
-		user.Token = &token
-		user.Refresh_token = &refreshToken
-
-		// Insert user into main collection
-		_, err = userCollection.InsertOne(ctx, user)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the email"})
 			return
 		}
 
-		// Delete verification data
-		_, err = database.OpenCollection(database.Client, "pending_verifications").DeleteOne(ctx, bson.M{"verify_token": token})
-		if err != nil {
-			log.Printf("Failed to delete verification data: %v", err)
+		completeVerification(c, ctx, pending)
+	}
+}
+
+// completeVerification finishes a pending signup that was already claimed (and removed) from
+// pending_verifications, both the link and the code verification end up here.
+func completeVerification(c *gin.Context, ctx context.Context, pending models.PendingVerification) {
+	// Check if token has expired, the record is already gone so the user has to sign up again.
+	if time.Now().After(pending.Verify_expires) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "verification token has expired"})
+		return
+	}
+
+	user, err := createVerifiedUser(ctx, pending)
+	if errors.Is(err, errAccountExists) {
+		// another verification got there first, there is nothing to give back.
+		helper.RecordAudit(c, helper.AuditEmailVerified, pending.Email, helper.AuditFailure, map[string]interface{}{"reason": "account_exists"})
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		helper.RecordAudit(c, helper.AuditEmailVerified, pending.Email, helper.AuditFailure, map[string]interface{}{"reason": err.Error()})
+		// give the record back, so the same link can be used again.
+		if _, restoreErr := pendingCollection.InsertOne(ctx, pending); restoreErr != nil {
+			log.Printf("Failed to restore verification data: %v", restoreErr)
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Email verified successfully. You can now login.",
-			"user": user,
-		})
+	helper.RecordAudit(c, helper.AuditEmailVerified, user.User_id, helper.AuditSuccess, map[string]interface{}{"verification_mode": pending.Verify_mode})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email verified successfully. You can now login.",
+		"user":    models.NewUserResponse(user),
+	})
+}
+
+// createVerifiedUser moves a claimed pending signup into the user collection.
+func createVerifiedUser(ctx context.Context, pending models.PendingVerification) (models.User, error) {
+	var user models.User
+
+	// the same email could have been verified through another pending record in the meantime,
+	// and in conceal mode the signup was let through with a phone number that is already taken.
+	count, err := userCollection.CountDocuments(ctx, bson.M{"$or": []bson.M{{"email": pending.Email}, {"phone": pending.Phone}}})
+	if err != nil {
+		return user, errors.New("error occurred while checking for the email")
+	}
+	if count > 0 {
+		return user, errAccountExists
+	}
+
+	user = models.User{
+		ID:         primitive.NewObjectID(),
+		First_name: &pending.First_name,
+		Last_name:  &pending.Last_name,
+		Password:   &pending.Password,
+		Email:      &pending.Email,
+		Phone:      &pending.Phone,
+		User_type:  &pending.User_type,
+		Language:   &pending.Language,
+		Created_at: time.Now(),
+		Updated_at: time.Now(),
+		Password_changed_at: time.Now(),
+		IsVerified: true,
 	}
+	user.User_id = user.ID.Hex()
+
+	// no tokens here, they come with the session of the first login.
+	// Insert user into main collection
+	// the count above is only the friendly answer, two verifications at the same time
+	// both pass it and the unique index is what stops the second one.
+	if _, err = userCollection.InsertOne(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return user, errAccountExists
+		}
+		return user, errors.New("Failed to create user")
+	}
+	recordPasswordHistory(ctx, user.User_id, *user.Password)
+	return user, nil
 }
 
 func Login() gin.HandlerFunc{
@@ -222,34 +472,97 @@ func Login() gin.HandlerFunc{
 		}
 
 
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+
+		// a locked or slowed down email is refused before any password is checked.
+		wait, err := loginWait(ctx, *user.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while logging in"})
+			return
+		}
+		if wait > 0 {
+			helper.RecordAudit(c, helper.AuditLogin, *user.Email, helper.AuditDenied, map[string]interface{}{"reason": "locked"})
+			respondLoginLocked(c, wait)
+			return
+		}
+
 		// by using the email, we store the user information, in foundUser struct
-		err := userCollection.FindOne(ctx, bson.M{"email":user.Email}).Decode(&foundUser)
+		err = userCollection.FindOne(ctx, bson.M{"email":user.Email}).Decode(&foundUser)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error":"email or password is incorrect"})
+			if err = verifyDummyPassword(*user.Password); err != nil {
+				respondHashError(c, err)
+				return
+			}
+			recordFailedLogin(ctx, *user.Email)
+			helper.RecordAudit(c, helper.AuditLogin, *user.Email, helper.AuditFailure, map[string]interface{}{"reason": "unknown_email"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": loginFailedMessage})
 			return
 		}
 
 		// and by using the verify password function we verfiy that the user password, will match with foundUser or not.
-		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
+		passwordIsValid, msg, err := VerifyPassword(*user.Password, *foundUser.Password)
 		defer cancel()
+		if err != nil {
+			respondHashError(c, err)
+			return
+		}
 		if passwordIsValid != true{
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			helper.RecordAudit(c, helper.AuditLogin, foundUser.User_id, helper.AuditFailure, map[string]interface{}{"reason": "wrong_password"})
+			if recordFailedLogin(ctx, *user.Email) {
+				helper.RecordAudit(c, helper.AuditLockout, foundUser.User_id, helper.AuditSuccess, nil)
+				notifyLockout(foundUser)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
 			return
 		}
+		resetFailedLogins(ctx, *user.Email)
+
+		// the password is checked first, so these answers don't tell anybody else the account exists.
+		if reason, response := loginBlocked(foundUser, time.Now()); reason != "" {
+			helper.RecordAudit(c, helper.AuditLogin, foundUser.User_id, helper.AuditDenied, map[string]interface{}{"reason": reason})
+			c.JSON(http.StatusForbidden, response)
+			return
+		}
+
+		// an expired password has to be changed first, through users/password/change-expired.
+		if passwordExpired(foundUser) {
+			helper.RecordAudit(c, helper.AuditLogin, foundUser.User_id, helper.AuditDenied, map[string]interface{}{"reason": "password_expired"})
+			c.JSON(http.StatusForbidden, gin.H{"error": "password has expired and must be changed", "code": "password_expired"})
+			return
+		}
+
+		// the stored hash is upgraded when it was made with an older algorithm or cost,
+		// this is the only moment we have the plain password for it.
+		if hashing.NeedsRehash(*foundUser.Password) {
+			rehashPassword(ctx, foundUser, *user.Password)
+		}
 
 		if foundUser.Email == nil{
 			c.JSON(http.StatusInternalServerError, gin.H{"error":"user not found"})
 		}
-		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *foundUser.User_type, foundUser.User_id)
-		helper.UpdateAllTokens(token, refreshToken, foundUser.User_id)
-		err = userCollection.FindOne(ctx, bson.M{"user_id":foundUser.User_id}).Decode(&foundUser)
-
+		// every login is a session of its own, so the devices can be logged out one by one.
+		login := recordLogin(c, ctx, foundUser)
+		token, refreshToken, err := startSession(ctx, foundUser, login)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while starting the session"})
+			return
+		}
+		helper.RecordAudit(c, helper.AuditLogin, foundUser.User_id, helper.AuditSuccess, nil)
+		// with ?mode=cookie the tokens stay out of reach of the page.
+		if helper.CookieMode(c) {
+			helper.SetSessionCookies(c, token, refreshToken)
+			c.JSON(http.StatusOK, models.NewUserResponse(foundUser))
 			return
 		}
-		c.JSON(http.StatusOK, foundUser)
+		c.JSON(http.StatusOK, models.LoginResponse{
+			UserResponse:  models.NewUserResponse(foundUser),
+			Token:         token,
+			Refresh_token: refreshToken,
+		})
 	}
 }
 
@@ -290,12 +603,24 @@ result,err := userCollection.Aggregate(ctx, mongo.Pipeline{
 defer cancel()
 if err!=nil{
 	c.JSON(http.StatusInternalServerError, gin.H{"error":"error occured while listing user items"})
+	return
+}
+// the documents are decoded into users, so they only leave through the response type.
+var allusers []struct{
+	Total_count	int				`bson:"total_count"`
+	User_items	[]models.User	`bson:"user_items"`
 }
-var allusers []bson.M
 if err = result.All(ctx, &allusers); err!=nil{
-	log.Fatal(err)
+	log.Printf("Failed to decode the user items: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error":"error occured while listing user items"})
+	return
 }
-c.JSON(http.StatusOK, allusers[0])}}
+response := gin.H{"total_count": 0, "user_items": []models.UserResponse{}}
+if len(allusers) > 0 {
+	response = gin.H{"total_count": allusers[0].Total_count, "user_items": models.NewUserResponses(allusers[0].User_items)}
+}
+helper.RecordAudit(c, helper.AuditUsersListed, "", helper.AuditSuccess, map[string]interface{}{"page": page, "recordPerPage": recordPerPage})
+c.JSON(http.StatusOK, response)}}
 
 // gin gives access to its own handler function.
 func GetUser() gin.HandlerFunc{
@@ -303,11 +628,17 @@ func GetUser() gin.HandlerFunc{
 		userId := c.Param("user_id")
 
 		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
+			helper.RecordAudit(c, helper.AuditUserRead, userId, helper.AuditDenied, nil)
 			c.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
 			return
 		}
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
+		// reading somebody else's account is worth a line in the audit log.
+		if userId != c.GetString("uid") {
+			helper.RecordAudit(c, helper.AuditUserRead, userId, helper.AuditSuccess, nil)
+		}
+
 		var user models.User
 		err := userCollection.FindOne(ctx, bson.M{"user_id":userId}).Decode(&user)
 		// we use decode function beacuse go does not understand the json format.
@@ -316,6 +647,6 @@ func GetUser() gin.HandlerFunc{
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, user)
+		c.JSON(http.StatusOK, models.NewUserResponse(user))
 	}
 }
\ No newline at end of file
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"jwtauth/hashing"
+)
+
+func TestRespondHashError(t *testing.T) {
+	cases := []struct {
+		name           string
+		err            error
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "pool busy", err: hashing.ErrBusy, wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "1"},
+		{name: "unknown hash", err: hashing.ErrUnknownHash, wantStatus: http.StatusInternalServerError},
+		{name: "other error", err: errors.New("boom"), wantStatus: http.StatusInternalServerError},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			respondHashError(c, tc.err)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tc.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tc.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tc.wantRetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jwtauth/database"
+	"jwtauth/models"
+	"jwtauth/services"
+)
+
+var signupNoticeCollection *mongo.Collection = database.OpenCollection(database.Client, "signup_notices")
+
+// errSendLimited is returned when the cooldown or the daily cap doesn't allow another email yet.
+type errSendLimited struct {
+	retryAfter time.Duration
+}
+
+func (e *errSendLimited) Error() string {
+	return "too many verification emails, please try again later"
+}
+
+// reservePendingSend collapses every pending signup for the email into the newest one, checks the
+// resend limits on it and applies set together with the new counters. The update is conditional on
+// the last_sent_at we read, so two concurrent requests can't both send.
+// mongo.ErrNoDocuments means there is no pending signup for the email.
+func reservePendingSend(ctx context.Context, email string, set bson.M) (models.PendingVerification, error) {
+	var pending []models.PendingVerification
+	cursor, err := pendingCollection.Find(ctx, bson.M{"email": email}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return models.PendingVerification{}, err
+	}
+	if err = cursor.All(ctx, &pending); err != nil {
+		return models.PendingVerification{}, err
+	}
+	if len(pending) == 0 {
+		return models.PendingVerification{}, mongo.ErrNoDocuments
+	}
+
+	// older duplicates from signing up again are dropped, only one link stays valid.
+	current := pending[0]
+	if len(pending) > 1 {
+		var duplicates []interface{}
+		for _, p := range pending[1:] {
+			duplicates = append(duplicates, p.ID)
+		}
+		if _, err = pendingCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicates}}); err != nil {
+			log.Printf("Failed to remove duplicate verification data: %v", err)
+		}
+	}
+
+	now := time.Now()
+	if wait, ok := services.CheckResendAllowed(current.Last_sent_at, current.Sent_day, current.Sent_count, now); !ok {
+		return current, &errSendLimited{retryAfter: wait}
+	}
+
+	sentCount := 1
+	if current.Sent_day == services.SendDay(now) {
+		sentCount = current.Sent_count + 1
+	}
+	update := bson.M{}
+	for k, v := range set {
+		update[k] = v
+	}
+	update["last_sent_at"] = now
+	update["sent_day"] = services.SendDay(now)
+	update["sent_count"] = sentCount
+
+	var updated models.PendingVerification
+	err = pendingCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": current.ID, "last_sent_at": current.Last_sent_at},
+		bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		// somebody else sent an email for this record in the meantime.
+		return current, &errSendLimited{retryAfter: services.VerificationResendCooldown()}
+	}
+	return updated, err
+}
+
+// reserveSignupNotice says whether the account exists notice for the email can go out now, under the
+// same cooldown and daily cap as the verification emails. Like reservePendingSend the update is
+// conditional on the last_sent_at we read, so two concurrent signups can't both send.
+func reserveSignupNotice(ctx context.Context, email string) (bool, error) {
+	now := time.Now()
+	var notice models.SignupNotice
+	err := signupNoticeCollection.FindOne(ctx, bson.M{"email": email}).Decode(&notice)
+	if err == mongo.ErrNoDocuments {
+		_, err = signupNoticeCollection.InsertOne(ctx, models.SignupNotice{
+			Email:        email,
+			Last_sent_at: now,
+			Sent_day:     services.SendDay(now),
+			Sent_count:   1,
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := services.CheckResendAllowed(notice.Last_sent_at, notice.Sent_day, notice.Sent_count, now); !ok {
+		return false, nil
+	}
+	sentCount := 1
+	if notice.Sent_day == services.SendDay(now) {
+		sentCount = notice.Sent_count + 1
+	}
+	result, err := signupNoticeCollection.UpdateOne(ctx,
+		bson.M{"email": email, "last_sent_at": notice.Last_sent_at},
+		bson.M{"$set": bson.M{"last_sent_at": now, "sent_day": services.SendDay(now), "sent_count": sentCount}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// ensureSignupNoticeIndexes keeps one counter per email and removes it once the daily cap can't
+// apply to it anymore.
+func ensureSignupNoticeIndexes(ctx context.Context) error {
+	ttl := int32((24*time.Hour + services.VerificationResendCooldown()).Seconds())
+	_, err := signupNoticeCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true).SetName("email_unique")},
+		{Keys: bson.D{{Key: "last_sent_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(ttl).SetName("last_sent_at_ttl")},
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 85 {
+		// IndexOptionsConflict: the cooldown changed since the ttl index was created.
+		err = signupNoticeCollection.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: signupNoticeCollection.Name()},
+			{Key: "index", Value: bson.M{"name": "last_sent_at_ttl", "expireAfterSeconds": ttl}},
+		}).Err()
+	}
+	return err
+}
+
+// respondSendLimited writes the 429 with a Retry-After header.
+func respondSendLimited(c *gin.Context, err error) bool {
+	var limited *errSendLimited
+	if !errors.As(err, &limited) {
+		return false
+	}
+	seconds := int(math.Ceil(limited.retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": limited.Error(), "retry_after": seconds})
+	return true
+}
+
+// newVerificationSecret creates a fresh link token or code for a pending signup and returns the
+// fields to store for it. In code mode the token is still set, but it is never emailed.
+func newVerificationSecret(email string, mode string) (set bson.M, verifyToken string, code string) {
+	verifyToken = services.GenerateVerificationToken()
+	set = bson.M{
+		"verify_mode":    mode,
+		"verify_token":   verifyToken,
+		"verify_expires": services.GetVerificationExpiryTime(),
+		"code_hash":      "",
+		"code_attempts":  0,
+	}
+	if mode == services.VerificationModeCode {
+		code = services.GenerateVerificationCode()
+		set["code_hash"] = services.HashVerificationCode(email, code)
+		set["verify_expires"] = time.Now().Add(services.VerificationCodeTTL())
+	}
+	return set, verifyToken, code
+}
+
+// sendVerification emails either the link or the code, depending on the mode.
+func sendVerification(email string, name string, language string, mode string, verifyToken string, code string) error {
+	emailService := services.NewEmailService()
+	if mode == services.VerificationModeCode {
+		return emailService.SendVerificationCode(email, name, language, code)
+	}
+	return emailService.SendVerificationEmail(email, name, language, verifyToken)
+}
+
+// ResendVerificationEmail sends a new link for a signup that is still waiting for verification.
+func ResendVerificationEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// the answer is the same whether there is a pending signup or not.
+		response := gin.H{"message": "If a signup is waiting for verification, a new email has been sent."}
+
+		// the new email is in the same mode as the signup, unless another mode is asked for.
+		var existing models.PendingVerification
+		err := pendingCollection.FindOne(ctx, bson.M{"email": body.Email}, options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while resending the verification email"})
+			return
+		}
+		mode := existing.Verify_mode
+		if mode == "" || c.Query("verification_mode") != "" {
+			mode = services.VerificationMode(c.Query("verification_mode"))
+		}
+
+		set, verifyToken, code := newVerificationSecret(body.Email, mode)
+		pending, err := reservePendingSend(ctx, body.Email, set)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		if respondSendLimited(c, err) {
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while resending the verification email"})
+			return
+		}
+
+		if err = sendVerification(pending.Email, pending.First_name, pending.Language, mode, verifyToken, code); err != nil {
+			log.Printf("Failed to queue verification email: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// VerifyEmailCode completes a signup made in code mode, for the clients that can't open the link.
+func VerifyEmailCode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Email string `json:"email" validate:"required,email"`
+			Code  string `json:"code" validate:"required,len=6,numeric"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var pending models.PendingVerification
+		err := pendingCollection.FindOne(ctx,
+			bson.M{"email": body.Email, "verify_mode": services.VerificationModeCode},
+			options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+		).Decode(&pending)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verification code"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the email"})
+			return
+		}
+
+		maxAttempts := services.VerificationCodeMaxAttempts()
+		if pending.Code_attempts >= maxAttempts || pending.Code_hash == "" {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many wrong codes, please request a new one"})
+			return
+		}
+		if time.Now().After(pending.Verify_expires) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "verification code has expired"})
+			return
+		}
+
+		// the attempt is reserved before the code is compared, in one atomic step that also checks the
+		// limit, so parallel guesses can't all read the same count and get past it.
+		err = pendingCollection.FindOneAndUpdate(ctx,
+			bson.M{"_id": pending.ID, "code_hash": pending.Code_hash, "code_attempts": bson.M{"$lt": maxAttempts}},
+			bson.M{"$inc": bson.M{"code_attempts": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&pending)
+		if err == mongo.ErrNoDocuments {
+			// used up by the other attempts, or the code was replaced or already used in the meantime.
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many wrong codes, please request a new one"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the email"})
+			return
+		}
+
+		if !services.VerificationCodeMatches(pending.Code_hash, body.Email, body.Code) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":              "invalid verification code",
+				"attempts_remaining": maxAttempts - pending.Code_attempts,
+			})
+			return
+		}
+
+		// claim the record the same way VerifyEmail does, the filter makes sure the code
+		// wasn't replaced or used by a concurrent request in the meantime.
+		err = pendingCollection.FindOneAndDelete(ctx, bson.M{
+			"_id":       pending.ID,
+			"code_hash": pending.Code_hash,
+		}).Decode(&pending)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verification code"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while verifying the email"})
+			return
+		}
+
+		completeVerification(c, ctx, pending)
+	}
+}
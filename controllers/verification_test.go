@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jwtauth/models"
+	"jwtauth/services"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// requireMongo skips the test unless TEST_MONGODB=1 is set and the server in MONGODB_URL answers,
+// these tests write into the database so it should be a throwaway one.
+func requireMongo(t *testing.T) context.Context {
+	t.Helper()
+	if os.Getenv("TEST_MONGODB") != "1" {
+		t.Skip("set TEST_MONGODB=1 and MONGODB_URL to run the database tests")
+	}
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelPing()
+	if err := userCollection.Database().Client().Ping(pingCtx, nil); err != nil {
+		t.Skipf("MongoDB is not reachable: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	if err := EnsureUserIndexes(ctx); err != nil {
+		t.Fatalf("EnsureUserIndexes: %v", err)
+	}
+	return ctx
+}
+
+func testPending(email, phone string) models.PendingVerification {
+	return models.PendingVerification{
+		ID:             primitive.NewObjectID(),
+		First_name:     "Test",
+		Last_name:      "User",
+		Password:       "$2a$04$0000000000000000000000000000000000000000000000000000.",
+		Email:          email,
+		Phone:          phone,
+		User_type:      "USER",
+		Language:       "en",
+		Verify_token:   uuid.NewString(),
+		Verify_expires: time.Now().Add(time.Hour),
+		Created_at:     time.Now(),
+		Verify_mode:    "link",
+	}
+}
+
+func testPhone() string {
+	// a unique number for every run, in the E.164 format.
+	return "+1555" + time.Now().Format("150405") + uuid.NewString()[:4]
+}
+
+func cleanupUsers(t *testing.T, filter bson.M) {
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var users []models.User
+		cursor, err := userCollection.Find(ctx, filter)
+		if err == nil {
+			_ = cursor.All(ctx, &users)
+		}
+		for _, user := range users {
+			_, _ = passwordHistoryCollection.DeleteMany(ctx, bson.M{"user_id": user.User_id})
+		}
+		_, _ = userCollection.DeleteMany(ctx, filter)
+		_, _ = pendingCollection.DeleteMany(ctx, filter)
+	})
+}
+
+// runParallel starts n copies of fn at the same moment and waits for all of them.
+func runParallel(n int, fn func(i int)) {
+	var ready, done sync.WaitGroup
+	start := make(chan struct{})
+	ready.Add(n)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer done.Done()
+			ready.Done()
+			<-start
+			fn(i)
+		}(i)
+	}
+	ready.Wait()
+	close(start)
+	done.Wait()
+}
+
+func TestCreateVerifiedUserParallelSameEmail(t *testing.T) {
+	ctx := requireMongo(t)
+	email := "verify-" + uuid.NewString() + "@example.com"
+	cleanupUsers(t, bson.M{"email": email})
+
+	const n = 8
+	errs := make([]error, n)
+	runParallel(n, func(i int) {
+		_, errs[i] = createVerifiedUser(ctx, testPending(email, testPhone()))
+	})
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, errAccountExists):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("created %d users, want 1", created)
+	}
+	count, err := userCollection.CountDocuments(ctx, bson.M{"email": email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("%d users stored with the email, want 1", count)
+	}
+}
+
+func TestCreateVerifiedUserParallelSamePhone(t *testing.T) {
+	ctx := requireMongo(t)
+	phone := testPhone()
+	cleanupUsers(t, bson.M{"phone": phone})
+
+	const n = 8
+	errs := make([]error, n)
+	runParallel(n, func(i int) {
+		_, errs[i] = createVerifiedUser(ctx, testPending("verify-"+uuid.NewString()+"@example.com", phone))
+	})
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, errAccountExists):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("created %d users, want 1", created)
+	}
+}
+
+func TestVerifyEmailParallelSameToken(t *testing.T) {
+	ctx := requireMongo(t)
+	gin.SetMode(gin.TestMode)
+	email := "verify-" + uuid.NewString() + "@example.com"
+	cleanupUsers(t, bson.M{"email": email})
+
+	pending := testPending(email, testPhone())
+	if _, err := pendingCollection.InsertOne(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/users/verify-email", VerifyEmail())
+
+	const n = 8
+	codes := make([]int, n)
+	runParallel(n, func(i int) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/users/verify-email?token="+pending.Verify_token, nil)
+		router.ServeHTTP(w, req)
+		codes[i] = w.Code
+	})
+
+	ok := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusBadRequest:
+			// the record was already claimed by another request.
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("%d verifications succeeded, want 1", ok)
+	}
+}
+
+func TestVerifyEmailCodeParallelGuesses(t *testing.T) {
+	ctx := requireMongo(t)
+	gin.SetMode(gin.TestMode)
+	email := "verify-" + uuid.NewString() + "@example.com"
+	cleanupUsers(t, bson.M{"email": email})
+
+	pending := testPending(email, testPhone())
+	pending.Verify_mode = services.VerificationModeCode
+	pending.Code_hash = services.HashVerificationCode(email, "123456")
+	if _, err := pendingCollection.InsertOne(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.POST("/users/verify-email/code", VerifyEmailCode())
+
+	// every guess is wrong, only the allowed number of them may even be compared.
+	const n = 20
+	codes := make([]int, n)
+	runParallel(n, func(i int) {
+		w := httptest.NewRecorder()
+		body := `{"email":"` + email + `","code":"` + fmt.Sprintf("%06d", 200000+i) + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/users/verify-email/code", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		codes[i] = w.Code
+	})
+
+	compared := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusBadRequest:
+			compared++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if max := services.VerificationCodeMaxAttempts(); compared != max {
+		t.Fatalf("%d guesses were compared, want %d", compared, max)
+	}
+}
@@ -15,14 +15,18 @@ import(
 
 func DBinstance() *mongo.Client{
 
-	// load the env for functioning.
-	err := godotenv.Load(".env")
-	if err!=nil{
-		log.Fatal("Error loading .env file")
+	// load the env for functioning, the variables can also come from the environment itself
+	// (and the tests run from the package folder, where there is no .env).
+	if err := godotenv.Load(".env"); err != nil {
+		log.Printf("No .env file loaded: %v", err)
 	}
 
-	//mongo url for passing the instance.
+	//mongo url for passing the instance, a local server when it isn't set.
 	MongoDb := os.Getenv("MONGODB_URL")
+	if MongoDb == "" {
+		MongoDb = "mongodb://localhost:27017"
+		log.Printf("MONGODB_URL is not set, using %s", MongoDb)
+	}
 
 	//making the client to act as the interface.
 	client, err:= mongo.NewClient(options.Client().ApplyURI(MongoDb))
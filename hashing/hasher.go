@@ -0,0 +1,223 @@
+package hashing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher is one password hashing algorithm. The encoded hashes carry their own algorithm and
+// parameters (PHC string format for argon2id, the usual $2a$ format for bcrypt), so old hashes
+// keep verifying after the settings change.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify is only called with hashes that Identify accepted.
+	Verify(password string, encoded string) (bool, error)
+	Identify(encoded string) bool
+	// Outdated tells if a hash of this algorithm was made with other parameters than the current ones.
+	Outdated(encoded string) bool
+}
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Current is the hasher new passwords are hashed with, PASSWORD_HASH_ALGO is bcrypt or argon2id.
+// It stays bcrypt by default until the stored hashes are migrated, the login times of the known
+// and the unknown emails have to match the hashes that are actually stored.
+func Current() Hasher {
+	return ForAlgorithm(os.Getenv("PASSWORD_HASH_ALGO"))
+}
+
+// ForAlgorithm is the hasher of "argon2id" or "bcrypt" with the parameters from the env,
+// anything else is bcrypt.
+func ForAlgorithm(algo string) Hasher {
+	if strings.ToLower(algo) == "argon2id" {
+		return argon2idFromEnv()
+	}
+	return bcryptFromEnv()
+}
+
+// hasherFor finds the algorithm of an existing hash.
+func hasherFor(encoded string) (Hasher, error) {
+	for _, h := range []Hasher{argon2idFromEnv(), bcryptFromEnv()} {
+		if h.Identify(encoded) {
+			return h, nil
+		}
+	}
+	return nil, ErrUnknownHash
+}
+
+// NeedsRehash is true when the hash isn't what Current would produce today, the login uses it
+// to upgrade the stored hash while it has the plain password at hand.
+func NeedsRehash(encoded string) bool {
+	current := Current()
+	return !current.Identify(encoded) || current.Outdated(encoded)
+}
+
+// HashPassword hashes on the pool, it fails with ErrBusy when the pool is overloaded.
+func HashPassword(ctx context.Context, password string) (string, error) {
+	return HashWith(ctx, Current(), password)
+}
+
+// HashWith is HashPassword with another hasher than Current.
+func HashWith(ctx context.Context, hasher Hasher, password string) (string, error) {
+	var hash string
+	var err error
+	if poolErr := DefaultPool().Do(ctx, func() {
+		hash, err = hasher.Hash(password)
+	}); poolErr != nil {
+		return "", poolErr
+	}
+	return hash, err
+}
+
+// ComparePassword checks the password against a hash of any known algorithm, on the pool.
+func ComparePassword(ctx context.Context, encoded string, password string) (bool, error) {
+	hasher, err := hasherFor(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	var ok bool
+	if poolErr := DefaultPool().Do(ctx, func() {
+		ok, err = hasher.Verify(password, encoded)
+	}); poolErr != nil {
+		return false, poolErr
+	}
+	return ok, err
+}
+
+// BcryptCost is BCRYPT_COST, or 14, the benchmarks in this package show what each cost takes on this machine.
+func BcryptCost() int {
+	return envInt("BCRYPT_COST", 14)
+}
+
+// BcryptMaxLength is the longest password bcrypt takes, in bytes, longer ones fail to hash.
+const BcryptMaxLength = 72
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func bcryptFromEnv() BcryptHasher {
+	return BcryptHasher{Cost: BcryptCost()}
+}
+
+func (b BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hash), err
+}
+
+func (b BcryptHasher) Verify(password string, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b BcryptHasher) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b BcryptHasher) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+// Argon2idHasher writes hashes like $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+type Argon2idHasher struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+func argon2idFromEnv() Argon2idHasher {
+	return Argon2idHasher{
+		Memory:      uint32(envInt("ARGON2_MEMORY", 64*1024)),
+		Iterations:  uint32(envInt("ARGON2_ITERATIONS", 3)),
+		Parallelism: uint8(envInt("ARGON2_PARALLELISM", 2)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (a Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2idHasher) Verify(password string, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
+
+func (a Argon2idHasher) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a Argon2idHasher) Outdated(encoded string) bool {
+	params, _, key, err := decodeArgon2id(encoded)
+	return err != nil ||
+		params.Memory != a.Memory ||
+		params.Iterations != a.Iterations ||
+		params.Parallelism != a.Parallelism ||
+		uint32(len(key)) != a.KeyLength
+}
+
+func decodeArgon2id(encoded string) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+	// argon2.IDKey panics on zero time or threads, and a stored hash is not trusted input.
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if len(salt) == 0 || len(key) == 0 {
+		return params, nil, nil, errors.New("argon2 hash without a salt or a key")
+	}
+	params.SaltLength = len(salt)
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
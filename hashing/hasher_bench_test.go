@@ -0,0 +1,82 @@
+package hashing
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// these benchmarks help to pick BCRYPT_COST, the ARGON2_* parameters and HASH_WORKERS.
+// Run them on the production hardware, with the same env:
+//
+//	go test ./hashing -run '^$' -bench . -benchtime 5x
+//
+// The hashers read their parameters from the env just like the server does.
+
+const benchPassword = "benchmark-password"
+
+func BenchmarkBcryptHash(b *testing.B) {
+	for cost := 10; cost <= 14; cost++ {
+		hasher := BcryptHasher{Cost: cost}
+		b.Run(fmt.Sprintf("cost=%d", cost), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := hasher.Hash(benchPassword); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBcryptVerify(b *testing.B) {
+	benchmarkVerify(b, bcryptFromEnv())
+}
+
+func BenchmarkArgon2idHash(b *testing.B) {
+	hasher := argon2idFromEnv()
+	for i := 0; i < b.N; i++ {
+		if _, err := hasher.Hash(benchPassword); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkArgon2idVerify(b *testing.B) {
+	benchmarkVerify(b, argon2idFromEnv())
+}
+
+func benchmarkVerify(b *testing.B, hasher Hasher) {
+	encoded, err := hasher.Hash(benchPassword)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ok, err := hasher.Verify(benchPassword, encoded)
+		if err != nil || !ok {
+			b.Fatalf("Verify = %v, %v", ok, err)
+		}
+	}
+}
+
+// BenchmarkHashPasswordParallel sends a burst through the pool with the current PASSWORD_HASH_ALGO,
+// busy/op is the share of the requests that got ErrBusy with the HASH_* settings.
+func BenchmarkHashPasswordParallel(b *testing.B) {
+	var busy, total int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			atomic.AddInt64(&total, 1)
+			_, err := HashPassword(context.Background(), benchPassword)
+			if err == ErrBusy {
+				atomic.AddInt64(&busy, 1)
+			} else if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	if total > 0 {
+		b.ReportMetric(float64(busy)/float64(total), "busy/op")
+	}
+}
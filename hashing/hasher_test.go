@@ -0,0 +1,143 @@
+package hashing
+
+import (
+	"strings"
+	"testing"
+)
+
+// small parameters, the tests are about the encoding and not about the cost.
+var (
+	testBcrypt   = BcryptHasher{Cost: 4}
+	testArgon2id = Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+)
+
+func TestHasherRoundTrip(t *testing.T) {
+	for name, hasher := range map[string]Hasher{"bcrypt": testBcrypt, "argon2id": testArgon2id} {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := hasher.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !hasher.Identify(encoded) {
+				t.Errorf("Identify(%q) = false", encoded)
+			}
+			if found, err := hasherFor(encoded); err != nil || !found.Identify(encoded) {
+				t.Errorf("hasherFor(%q) = %v, %v", encoded, found, err)
+			}
+			if ok, err := hasher.Verify("correct horse battery staple", encoded); !ok || err != nil {
+				t.Errorf("Verify(right password) = %v, %v", ok, err)
+			}
+			if ok, err := hasher.Verify("wrong password", encoded); ok || err != nil {
+				t.Errorf("Verify(wrong password) = %v, %v", ok, err)
+			}
+			if hasher.Outdated(encoded) {
+				t.Errorf("Outdated(%q) = true right after hashing", encoded)
+			}
+
+			again, _ := hasher.Hash("correct horse battery staple")
+			if again == encoded {
+				t.Errorf("two hashes of the same password are equal, the salt is missing")
+			}
+		})
+	}
+}
+
+func TestArgon2idMalformedHashes(t *testing.T) {
+	valid, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+	salt, key := parts[4], parts[5]
+
+	cases := map[string]string{
+		"empty":           "",
+		"not argon2id":    "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key,
+		"missing part":    "$argon2id$v=19$m=64,t=1,p=1$" + salt,
+		"extra part":      valid + "$extra",
+		"other version":   "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key,
+		"bad parameters":  "$argon2id$v=19$m=x,t=1,p=1$" + salt + "$" + key,
+		"zero memory":     "$argon2id$v=19$m=0,t=1,p=1$" + salt + "$" + key,
+		"zero iterations": "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key,
+		"zero threads":    "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key,
+		"threads too big": "$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key,
+		"bad salt":        "$argon2id$v=19$m=64,t=1,p=1$!!!$" + key,
+		"empty salt":      "$argon2id$v=19$m=64,t=1,p=1$$" + key,
+		"bad key":         "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!",
+		"empty key":       "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$",
+	}
+	for name, encoded := range cases {
+		t.Run(name, func(t *testing.T) {
+			// a panic here would take down the pool worker that runs it.
+			ok, err := testArgon2id.Verify("password", encoded)
+			if ok || err == nil {
+				t.Errorf("Verify(%q) = %v, %v, want an error", encoded, ok, err)
+			}
+			if !testArgon2id.Outdated(encoded) {
+				t.Errorf("Outdated(%q) = false for a hash that can't be read", encoded)
+			}
+		})
+	}
+}
+
+func TestHasherForUnknownHash(t *testing.T) {
+	for _, encoded := range []string{"", "plaintext", "$1$md5$hash", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		if _, err := hasherFor(encoded); err != ErrUnknownHash {
+			t.Errorf("hasherFor(%q) = %v, want ErrUnknownHash", encoded, err)
+		}
+	}
+}
+
+func TestOutdated(t *testing.T) {
+	bcryptHash, err := testBcrypt.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2idHash, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		hasher  Hasher
+		encoded string
+		want    bool
+	}{
+		{"bcrypt same cost", testBcrypt, bcryptHash, false},
+		{"bcrypt other cost", BcryptHasher{Cost: 5}, bcryptHash, true},
+		{"bcrypt malformed", testBcrypt, "$2a$xx$", true},
+		{"argon2id same parameters", testArgon2id, argon2idHash, false},
+		{"argon2id other memory", Argon2idHasher{Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, argon2idHash, true},
+		{"argon2id other iterations", Argon2idHasher{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}, argon2idHash, true},
+		{"argon2id other parallelism", Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}, argon2idHash, true},
+		{"argon2id other key length", Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64}, argon2idHash, true},
+		// the salt length is not a parameter of the algorithm, an older hash with another one still verifies.
+		{"argon2id other salt length", Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 8, KeyLength: 32}, argon2idHash, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.hasher.Outdated(tc.encoded); got != tc.want {
+				t.Errorf("Outdated(%q) = %v, want %v", tc.encoded, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "4")
+	t.Setenv("ARGON2_MEMORY", "64")
+	t.Setenv("ARGON2_ITERATIONS", "1")
+	t.Setenv("ARGON2_PARALLELISM", "1")
+	bcryptHash, _ := testBcrypt.Hash("password")
+	argon2idHash, _ := testArgon2id.Hash("password")
+
+	t.Setenv("PASSWORD_HASH_ALGO", "bcrypt")
+	if NeedsRehash(bcryptHash) || !NeedsRehash(argon2idHash) {
+		t.Errorf("with bcrypt: NeedsRehash(bcrypt) = %v, NeedsRehash(argon2id) = %v", NeedsRehash(bcryptHash), NeedsRehash(argon2idHash))
+	}
+	t.Setenv("PASSWORD_HASH_ALGO", "argon2id")
+	if !NeedsRehash(bcryptHash) || NeedsRehash(argon2idHash) {
+		t.Errorf("with argon2id: NeedsRehash(bcrypt) = %v, NeedsRehash(argon2id) = %v", NeedsRehash(bcryptHash), NeedsRehash(argon2idHash))
+	}
+}
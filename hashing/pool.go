@@ -0,0 +1,163 @@
+package hashing
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// password hashing is slow on purpose, so it runs on a fixed number of workers instead of
+// on every request goroutine. When the queue is full, or a job waits too long to start, the
+// caller gets ErrBusy right away and can answer 503 instead of piling up more work.
+
+var ErrBusy = errors.New("server is busy, please try again later")
+
+// the numbers are published with expvar, an admin can read them from /admin/metrics.
+var (
+	metrics        = expvar.NewMap("password_hashing")
+	metricRejected = new(expvar.Int)
+	metricTimeouts = new(expvar.Int)
+	metricDone     = new(expvar.Int)
+	metricWaitUs   = new(expvar.Int)
+	metricHashUs   = new(expvar.Int)
+	metricMaxHash  = new(expvar.Int)
+	metricDepth    = new(expvar.Int)
+	metricRunning  = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("rejected", metricRejected)
+	metrics.Set("timeouts", metricTimeouts)
+	metrics.Set("completed", metricDone)
+	metrics.Set("total_wait_us", metricWaitUs)
+	metrics.Set("total_hash_us", metricHashUs)
+	metrics.Set("max_hash_us", metricMaxHash)
+	metrics.Set("queue_depth", metricDepth)
+	metrics.Set("running", metricRunning)
+}
+
+type job struct {
+	fn       func()
+	queued   time.Time
+	started  int32
+	finished chan struct{}
+}
+
+type Pool struct {
+	jobs    chan *job
+	timeout time.Duration
+}
+
+// NewPool starts the workers, queue is how many jobs may wait, timeout how long they may wait.
+func NewPool(workers int, queue int, timeout time.Duration) *Pool {
+	p := &Pool{jobs: make(chan *job, queue), timeout: timeout}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+var (
+	defaultPool     *Pool
+	defaultPoolOnce sync.Once
+)
+
+// DefaultPool is sized by HASH_WORKERS (one per cpu by default), HASH_QUEUE and HASH_QUEUE_TIMEOUT.
+func DefaultPool() *Pool {
+	defaultPoolOnce.Do(func() {
+		defaultPool = NewPool(
+			envInt("HASH_WORKERS", runtime.GOMAXPROCS(0)),
+			envInt("HASH_QUEUE", 64),
+			envDuration("HASH_QUEUE_TIMEOUT", 2*time.Second),
+		)
+	})
+	return defaultPool
+}
+
+func (p *Pool) work() {
+	for j := range p.jobs {
+		metricDepth.Add(-1)
+		// the caller already gave up on this one.
+		if !atomic.CompareAndSwapInt32(&j.started, 0, 1) {
+			continue
+		}
+
+		start := time.Now()
+		metricWaitUs.Add(start.Sub(j.queued).Microseconds())
+		metricRunning.Add(1)
+		j.fn()
+		metricRunning.Add(-1)
+
+		took := time.Since(start).Microseconds()
+		metricHashUs.Add(took)
+		metricDone.Add(1)
+		if took > metricMaxHash.Value() {
+			metricMaxHash.Set(took)
+		}
+		close(j.finished)
+	}
+}
+
+// Do runs fn on a worker and waits for it, once fn has started it always runs to the end.
+func (p *Pool) Do(ctx context.Context, fn func()) error {
+	j := &job{fn: fn, queued: time.Now(), finished: make(chan struct{})}
+
+	select {
+	case p.jobs <- j:
+		metricDepth.Add(1)
+	default:
+		metricRejected.Add(1)
+		return ErrBusy
+	}
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-j.finished:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	// too late to start, unless a worker already picked it up.
+	if atomic.CompareAndSwapInt32(&j.started, 0, 1) {
+		metricTimeouts.Add(1)
+		return ErrBusy
+	}
+	<-j.finished
+	return nil
+}
+
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Printf("Invalid %s=%q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,129 @@
+package hashing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowHasher blocks in Hash until release is closed, so the test decides when the workers are free.
+type slowHasher struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h slowHasher) Hash(password string) (string, error) {
+	h.started <- struct{}{}
+	<-h.release
+	return "slow:" + password, nil
+}
+
+func (h slowHasher) Verify(password string, encoded string) (bool, error) {
+	return encoded == "slow:"+password, nil
+}
+
+func (h slowHasher) Identify(encoded string) bool {
+	return len(encoded) > 5 && encoded[:5] == "slow:"
+}
+
+func (h slowHasher) Outdated(encoded string) bool {
+	return false
+}
+
+func TestPoolDo(t *testing.T) {
+	cases := []struct {
+		name       string
+		workers    int
+		queue      int
+		timeout    time.Duration
+		running    int // jobs holding the workers
+		queued     int // jobs waiting behind them
+		ctxTimeout time.Duration
+		wantErr    error
+		minWait    time.Duration
+		maxWait    time.Duration
+	}{
+		{name: "free worker", workers: 1, queue: 1, timeout: time.Second, maxWait: 500 * time.Millisecond},
+		{name: "full queue", workers: 1, queue: 1, timeout: time.Second, running: 1, queued: 1, wantErr: ErrBusy, maxWait: 100 * time.Millisecond},
+		{name: "queue timeout", workers: 1, queue: 4, timeout: 50 * time.Millisecond, running: 1, wantErr: ErrBusy, minWait: 50 * time.Millisecond, maxWait: 500 * time.Millisecond},
+		{name: "caller gives up", workers: 1, queue: 4, timeout: time.Second, running: 1, ctxTimeout: 50 * time.Millisecond, wantErr: ErrBusy, minWait: 50 * time.Millisecond, maxWait: 500 * time.Millisecond},
+		{name: "second worker", workers: 2, queue: 1, timeout: time.Second, running: 1, maxWait: 500 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pool := NewPool(tc.workers, tc.queue, tc.timeout)
+			hasher := slowHasher{started: make(chan struct{}, tc.running+tc.queued), release: make(chan struct{})}
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer close(hasher.release)
+
+			occupy := func() {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					pool.Do(context.Background(), func() { hasher.Hash("busy") })
+				}()
+			}
+			for i := 0; i < tc.running; i++ {
+				occupy()
+				<-hasher.started
+			}
+			for i := 0; i < tc.queued; i++ {
+				occupy()
+			}
+			for len(pool.jobs) < tc.queued {
+				time.Sleep(time.Millisecond)
+			}
+
+			ctx := context.Background()
+			if tc.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.ctxTimeout)
+				defer cancel()
+			}
+			ran := false
+			start := time.Now()
+			err := pool.Do(ctx, func() { ran = true })
+			waited := time.Since(start)
+
+			if err != tc.wantErr {
+				t.Fatalf("Do() = %v, want %v", err, tc.wantErr)
+			}
+			if ran != (tc.wantErr == nil) {
+				t.Errorf("job ran = %v with error %v", ran, err)
+			}
+			if waited < tc.minWait || waited > tc.maxWait {
+				t.Errorf("Do() took %s, want between %s and %s", waited, tc.minWait, tc.maxWait)
+			}
+		})
+	}
+}
+
+// a job that timed out in the queue must not run later, when a worker frees up.
+func TestPoolSkipsAbandonedJobs(t *testing.T) {
+	pool := NewPool(1, 1, 20*time.Millisecond)
+	hasher := slowHasher{started: make(chan struct{}, 1), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Do(context.Background(), func() { hasher.Hash("busy") })
+	}()
+	<-hasher.started
+
+	ran := make(chan struct{}, 1)
+	if err := pool.Do(context.Background(), func() { ran <- struct{}{} }); err != ErrBusy {
+		t.Fatalf("Do() = %v, want ErrBusy", err)
+	}
+	close(hasher.release)
+	<-done
+
+	if err := pool.Do(context.Background(), func() {}); err != nil {
+		t.Fatalf("Do() after the release = %v", err)
+	}
+	select {
+	case <-ran:
+		t.Error("the abandoned job ran")
+	default:
+	}
+}
@@ -0,0 +1,162 @@
+package helper
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"jwtauth/database"
+	"jwtauth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// this file writes the security audit log, nothing in the code updates or deletes these entries.
+
+var auditCollection *mongo.Collection = database.OpenCollection(database.Client, "audit_events")
+
+// the actions in the audit log.
+const (
+	AuditSignup               = "signup"
+	AuditEmailVerified        = "email_verified"
+	AuditLogin                = "login"
+	AuditLockout              = "account_locked"
+	AuditUnlock               = "account_unlocked"
+	AuditTokenRejected        = "token_rejected"
+	AuditUsersListed          = "users_listed"
+	AuditUserRead             = "user_read"
+	AuditPasswordChanged      = "password_changed"
+	AuditPasswordReset        = "password_reset_requested"
+	AuditPhoneVerified        = "phone_verified"
+	AuditOutboxRetried        = "outbox_retried"
+	AuditAuditLogExported     = "audit_log_exported"
+	AuditTokenRefreshed       = "token_refreshed"
+	AuditSessionRevoked       = "session_revoked"
+	AuditReauthenticated      = "reauthenticated"
+	AuditProfileUpdated       = "profile_updated"
+	AuditEmailChangeRequested = "email_change_requested"
+	AuditEmailChanged         = "email_changed"
+	AuditAccountDeleted       = "account_deleted"
+	AuditAccountRestored      = "account_restored"
+	AuditAccountPurged        = "account_purged"
+	AuditAccountSuspended     = "account_suspended"
+	AuditAccountReactivated   = "account_reactivated"
+	AuditUserCreated          = "user_created"
+	AuditRoleChanged          = "role_changed"
+	AuditPasswordResetForced  = "password_reset_forced"
+	AuditEmailMarkedVerified  = "email_marked_verified"
+	AuditAccessDenied         = "access_denied"
+)
+
+// the actor of the events written by the background jobs.
+const AuditSystemActor = "system"
+
+// the outcomes of an action.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// RecordAudit writes one event, with the actor, ip, user agent and request id taken from the request.
+// A failed write is only logged, the request itself goes on.
+func RecordAudit(c *gin.Context, action string, subject string, outcome string, details map[string]interface{}) {
+	event := models.AuditEvent{
+		Timestamp:  time.Now(),
+		Action:     action,
+		Outcome:    outcome,
+		Actor_id:   c.GetString("uid"),
+		Subject:    subject,
+		IP:         c.ClientIP(),
+		User_agent: c.Request.UserAgent(),
+		Request_id: c.GetString("request_id"),
+		Details:    details,
+	}
+	writeAudit(event)
+}
+
+// RecordSystemAudit writes an event of the background jobs, which have no request.
+func RecordSystemAudit(action string, subject string, outcome string, details map[string]interface{}) {
+	writeAudit(models.AuditEvent{
+		Timestamp: time.Now(),
+		Action:    action,
+		Outcome:   outcome,
+		Actor_id:  AuditSystemActor,
+		Subject:   subject,
+		Details:   details,
+	})
+}
+
+func writeAudit(event models.AuditEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := auditCollection.InsertOne(ctx, event); err != nil {
+		log.Printf("Failed to write audit event %s: %v", event.Action, err)
+	}
+}
+
+// AuditQuery are the filters of the admin api, the empty ones are not applied.
+type AuditQuery struct {
+	Action     string
+	Outcome    string
+	Actor_id   string
+	Subject    string
+	IP         string
+	Request_id string
+	From       time.Time
+	To         time.Time
+}
+
+func (q AuditQuery) filter() bson.M {
+	filter := bson.M{}
+	for key, value := range map[string]string{
+		"action": q.Action, "outcome": q.Outcome, "actor_id": q.Actor_id,
+		"subject": q.Subject, "ip": q.IP, "request_id": q.Request_id,
+	} {
+		if value != "" {
+			filter[key] = value
+		}
+	}
+	timestamp := bson.M{}
+	if !q.From.IsZero() {
+		timestamp["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		timestamp["$lte"] = q.To
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+	return filter
+}
+
+// ListAuditEvents returns one page of events, the newest first.
+func ListAuditEvents(ctx context.Context, q AuditQuery, page int, recordPerPage int) ([]models.AuditEvent, int64, error) {
+	filter := q.filter()
+	total, err := auditCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := auditCollection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetSkip(int64((page-1)*recordPerPage)).
+		SetLimit(int64(recordPerPage)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []models.AuditEvent{}
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// AuditEventsCursor walks every matching event, the oldest first, for the export.
+func AuditEventsCursor(ctx context.Context, q AuditQuery) (*mongo.Cursor, error) {
+	return auditCollection.Find(ctx, q.filter(), options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}))
+}
@@ -2,6 +2,8 @@ package helper
 
 import (
 	"errors"
+	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -30,4 +32,23 @@ func MatchUserTypeToUid(c *gin.Context, userId string) (err error){
 	}
 	err = CheckUserType(c, userType)
 	return err
-}
\ No newline at end of file
+}
+
+// RecentlyAuthenticated tells if the caller logged in or reauthenticated in the last maxAge,
+// going by the auth_time claim of the token.
+func RecentlyAuthenticated(c *gin.Context, maxAge time.Duration) bool {
+	authTime := time.Unix(c.GetInt64("auth_time"), 0)
+	return time.Since(authTime) <= maxAge
+}
+
+// RespondStepUpRequired is the error of the sensitive actions when the caller hasn't authenticated
+// recently, the client has to call users/reauthenticate and retry with the new token.
+func RespondStepUpRequired(c *gin.Context, maxAge time.Duration) {
+	RecordAudit(c, AuditTokenRejected, c.GetString("uid"), AuditDenied, map[string]interface{}{"reason": "step_up_required"})
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error":          "this action requires you to confirm your identity again",
+		"code":           "step_up_required",
+		"max_age":        int(maxAge.Seconds()),
+		"reauthenticate": "/users/reauthenticate",
+	})
+}
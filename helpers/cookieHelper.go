@@ -0,0 +1,61 @@
+package helper
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"jwtauth/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// this file is for the browser clients, which get the tokens in HttpOnly cookies instead of
+// the response body, so no script on the page can read them.
+
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	// the csrf cookie is readable by the page, which sends it back in the CSRF header.
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// the refresh token cookie is only sent to the refresh route.
+const refreshCookiePath = "/users/refresh"
+
+// CookieMode tells if the client asked for the tokens in cookies, with ?mode=cookie.
+func CookieMode(c *gin.Context) bool {
+	return c.Query("mode") == "cookie"
+}
+
+// cookieSameSite reads COOKIE_SAMESITE, strict, lax or none, lax when not set.
+func cookieSameSite() http.SameSite {
+	switch strings.ToLower(os.Getenv("COOKIE_SAMESITE")) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func setCookie(c *gin.Context, name string, value string, maxAge int, path string, httpOnly bool) {
+	c.SetSameSite(cookieSameSite())
+	c.SetCookie(name, value, maxAge, path, os.Getenv("COOKIE_DOMAIN"), services.SecureCookies(), httpOnly)
+}
+
+// SetSessionCookies puts the tokens in cookies, along with a new csrf token.
+func SetSessionCookies(c *gin.Context, token string, refreshToken string) {
+	setCookie(c, AccessTokenCookie, token, int(AccessTokenTTL.Seconds()), "/", true)
+	setCookie(c, RefreshTokenCookie, refreshToken, int(RefreshTokenTTL.Seconds()), refreshCookiePath, true)
+	setCookie(c, CSRFCookie, services.GenerateSecureToken(), int(RefreshTokenTTL.Seconds()), "/", false)
+}
+
+// ClearSessionCookies removes the cookies, on logout.
+func ClearSessionCookies(c *gin.Context) {
+	setCookie(c, AccessTokenCookie, "", -1, "/", true)
+	setCookie(c, RefreshTokenCookie, "", -1, refreshCookiePath, true)
+	setCookie(c, CSRFCookie, "", -1, "/", false)
+}
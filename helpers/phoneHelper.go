@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// NormalizePhone turns what the user typed into the E.164 format, like +916386402690.
+// Numbers without a country code get DEFAULT_PHONE_COUNTRY_CODE (91 when it is not set).
+func NormalizePhone(raw string) (string, error) {
+	phone := strings.TrimSpace(raw)
+	international := false
+	switch {
+	case strings.HasPrefix(phone, "+"):
+		international = true
+		phone = phone[1:]
+	case strings.HasPrefix(phone, "00"):
+		international = true
+		phone = phone[2:]
+	}
+
+	var digits strings.Builder
+	for _, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			// separators people usually type, they are just dropped.
+		default:
+			return "", errors.New("phone number contains invalid characters")
+		}
+	}
+	number := digits.String()
+
+	if !international {
+		countryCode := os.Getenv("DEFAULT_PHONE_COUNTRY_CODE")
+		if countryCode == "" {
+			countryCode = "91"
+		}
+		// the leading 0 is the national trunk prefix, it is not part of the international number.
+		number = strings.TrimLeft(strings.TrimPrefix(countryCode, "+"), "0") + strings.TrimLeft(number, "0")
+	}
+
+	// E.164 allows at most 15 digits and a country code never starts with 0.
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", errors.New("invalid phone number")
+	}
+	return "+" + number, nil
+}
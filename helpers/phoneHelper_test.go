@@ -0,0 +1,48 @@
+package helper
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	cases := []struct {
+		name    string
+		country string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{name: "e164", raw: "+916386402690", want: "+916386402690"},
+		{name: "00 prefix", raw: "00916386402690", want: "+916386402690"},
+		{name: "separators", raw: " +1 (415) 555-0100 ", want: "+14155550100"},
+		{name: "dots", raw: "+44.20.7946.0958", want: "+442079460958"},
+		{name: "default country code", raw: "6386402690", want: "+916386402690"},
+		{name: "trunk 0 stripped", raw: "06386402690", want: "+916386402690"},
+		{name: "configured country code", country: "44", raw: "020 7946 0958", want: "+442079460958"},
+		{name: "configured country code with plus", country: "+1", raw: "415 555 0100", want: "+14155550100"},
+		{name: "international keeps its code", country: "44", raw: "+916386402690", want: "+916386402690"},
+		{name: "letters", raw: "+91 63864 ABCDE", wantErr: true},
+		{name: "too short", raw: "+1234567", wantErr: true},
+		{name: "shortest", raw: "+12345678", want: "+12345678"},
+		{name: "longest", raw: "+123456789012345", want: "+123456789012345"},
+		{name: "too long", raw: "+1234567890123456", wantErr: true},
+		{name: "country code starts with 0", raw: "+0123456789", wantErr: true},
+		{name: "empty", raw: "", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_PHONE_COUNTRY_CODE", tc.country)
+			got, err := NormalizePhone(tc.raw)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("NormalizePhone(%q) = %q, want an error", tc.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizePhone(%q): %v", tc.raw, err)
+			}
+			if got != tc.want {
+				t.Errorf("NormalizePhone(%q) = %q, want %q", tc.raw, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"jwtauth/database"
+	"jwtauth/models"
+	"jwtauth/services"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// this file keeps the sessions, one per login, so the tokens of one device can be revoked
+// without touching the others.
+
+var sessionCollection *mongo.Collection = database.OpenCollection(database.Client, "sessions")
+
+// last_used_at is only written once a minute, not on every request.
+const sessionTouchInterval = time.Minute
+
+var (
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrSessionRevoked   = errors.New("session has been revoked or has expired")
+	ErrRefreshReused    = errors.New("refresh token has already been used")
+	ErrAccountSuspended = errors.New("this account has been suspended")
+)
+
+// the acr values, there is only the password for now.
+const (
+	AcrPassword = "pwd"
+)
+
+// the reasons a session was revoked.
+const (
+	RevokedByUser              = "user"
+	RevokedRefreshReuse        = "refresh_token_reuse"
+	RevokedAccountDeleted      = "account_deleted"
+	RevokedAccountSuspended    = "account_suspended"
+	RevokedRoleChanged         = "role_changed"
+	RevokedPasswordResetForced = "password_reset_forced"
+	RevokedPasswordChanged     = "password_changed"
+)
+
+// StartSession stores a new session for the user, the device fields and the acr are taken from
+// the given one. refreshId goes into the refresh token, only its hash is stored.
+func StartSession(ctx context.Context, session models.Session) (stored models.Session, refreshId string, err error) {
+	now := time.Now()
+	refreshId = uuid.NewString()
+	session.ID = uuid.NewString()
+	session.Refresh_family = uuid.NewString()
+	session.Refresh_hash = services.HashToken(refreshId)
+	session.Created_at = now
+	session.Last_used_at = now
+	session.Auth_time = now
+	session.Max_expires_at = now.Add(services.SessionMaxLifetime(session.User_type))
+	session.Expires_at = sessionExpiry(session, now)
+
+	_, err = sessionCollection.InsertOne(ctx, session)
+	return session, refreshId, err
+}
+
+// sessionExpiry is when the session ends if it isn't used again after now, the idle timeout
+// from now but never past the absolute lifetime.
+func sessionExpiry(session models.Session, now time.Time) time.Time {
+	expires := now.Add(services.SessionIdleTimeout(session.User_type))
+	if expires.After(session.Max_expires_at) {
+		return session.Max_expires_at
+	}
+	return expires
+}
+
+// touchSession records a use of the session, which pushes its idle timeout back.
+func touchSession(ctx context.Context, session models.Session, now time.Time) error {
+	_, err := sessionCollection.UpdateOne(ctx, bson.M{"_id": session.ID}, bson.M{"$set": bson.M{
+		"last_used_at": now,
+		"expires_at":   sessionExpiry(session, now),
+	}})
+	return err
+}
+
+// activeSessionFilter matches the session only while it can still be used.
+func activeSessionFilter(sid string, uid string) bson.M {
+	return bson.M{
+		"_id":        sid,
+		"user_id":    uid,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+}
+
+// CheckSession returns ErrSessionRevoked when the session of an access token can't be used anymore,
+// or ErrAccountSuspended when it was ended by the suspension of the account.
+func CheckSession(ctx context.Context, sid string, uid string) error {
+	var session models.Session
+	err := sessionCollection.FindOne(ctx, bson.M{"_id": sid, "user_id": uid}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if session.Revoked_at != nil {
+		if session.Revoked_reason == RevokedAccountSuspended {
+			return ErrAccountSuspended
+		}
+		return ErrSessionRevoked
+	}
+	if !session.Expires_at.After(time.Now()) {
+		return ErrSessionRevoked
+	}
+
+	if time.Since(session.Last_used_at) > sessionTouchInterval {
+		touchSession(ctx, session, time.Now())
+	}
+	return nil
+}
+
+// RotateRefreshToken swaps the refresh token id of the session for a new one, returned as refreshId.
+// A refresh token that was already swapped out means it leaked, so the whole family is revoked
+// and ErrRefreshReused returned.
+func RotateRefreshToken(ctx context.Context, sid string, uid string, jti string) (session models.Session, refreshId string, err error) {
+	filter := activeSessionFilter(sid, uid)
+	filter["refresh_hash"] = services.HashToken(jti)
+
+	now := time.Now()
+	refreshId = uuid.NewString()
+	err = sessionCollection.FindOneAndUpdate(ctx, filter, bson.M{
+		"$set": bson.M{"refresh_hash": services.HashToken(refreshId)},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&session)
+	if err == nil {
+		// a refresh is a use too, the token swap above is what had to be atomic.
+		if err = touchSession(ctx, session, now); err != nil {
+			return session, "", err
+		}
+		return session, refreshId, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return session, "", err
+	}
+
+	// either the session is gone, or the token is an old one of the family.
+	err = sessionCollection.FindOne(ctx, activeSessionFilter(sid, uid)).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return session, "", ErrSessionRevoked
+	}
+	if err != nil {
+		return session, "", err
+	}
+	if _, err = sessionCollection.UpdateMany(ctx,
+		bson.M{"refresh_family": session.Refresh_family, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now, "revoked_reason": RevokedRefreshReuse}}); err != nil {
+		return session, "", err
+	}
+	return session, "", ErrRefreshReused
+}
+
+// Reauthenticate moves the auth_time of the session to now, after the user proved who they are again,
+// and swaps its refresh token id like a refresh does.
+func Reauthenticate(ctx context.Context, sid string, uid string, acr string) (session models.Session, refreshId string, err error) {
+	now := time.Now()
+	refreshId = uuid.NewString()
+	err = sessionCollection.FindOneAndUpdate(ctx, activeSessionFilter(sid, uid), bson.M{
+		"$set": bson.M{
+			"refresh_hash": services.HashToken(refreshId),
+			"auth_time":    now,
+			"acr":          acr,
+		},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return session, "", ErrSessionRevoked
+	}
+	if err != nil {
+		return session, "", err
+	}
+	if err = touchSession(ctx, session, now); err != nil {
+		return session, "", err
+	}
+	return session, refreshId, nil
+}
+
+// ListSessions returns the sessions of the user that can still be used, the most recently used first.
+func ListSessions(ctx context.Context, uid string) ([]models.Session, error) {
+	cursor, err := sessionCollection.Find(ctx, bson.M{
+		"user_id":    uid,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []models.Session{}
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one session of the user, its access and refresh tokens stop working right away.
+func RevokeSession(ctx context.Context, sid string, uid string, reason string) error {
+	result, err := sessionCollection.UpdateOne(ctx,
+		bson.M{"_id": sid, "user_id": uid, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions ends every session of the user, on all the devices.
+func RevokeUserSessions(ctx context.Context, uid string, reason string) error {
+	_, err := sessionCollection.UpdateMany(ctx,
+		bson.M{"user_id": uid, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}})
+	return err
+}
+
+// RevokeOtherSessions ends every session of the user except keepSid, the one the request came with.
+func RevokeOtherSessions(ctx context.Context, uid string, keepSid string, reason string) error {
+	_, err := sessionCollection.UpdateMany(ctx,
+		bson.M{"user_id": uid, "_id": bson.M{"$ne": keepSid}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}})
+	return err
+}
+
+// DeleteUserSessions removes the sessions of the user for good, when the account is purged.
+func DeleteUserSessions(ctx context.Context, uid string) error {
+	_, err := sessionCollection.DeleteMany(ctx, bson.M{"user_id": uid})
+	return err
+}
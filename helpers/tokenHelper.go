@@ -4,15 +4,14 @@ import (
 	"context"
 	"fmt"
 	"jwtauth/database"
+	"jwtauth/models"
 	"log"
 	"os"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // jwt token basically uses a hashing mechanism, by taking the details,
@@ -26,6 +25,10 @@ type SignedDetails struct{
 	Last_name 	string
 	Uid 		string
 	User_type	string
+	Sid			string	// the session the token belongs to.
+	Token_type	string	// access or refresh, so one can't be used as the other.
+	Auth_time	int64	// unix time of the last login or reauthentication of the session.
+	Acr			string	// how the user authenticated at Auth_time, see AcrPassword.
 	jwt.StandardClaims 
 }
 
@@ -34,23 +37,44 @@ var userCollection *mongo.Collection = database.OpenCollection(database.Client,
 
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
-func GenerateAllTokens(email string, firstName string, lastName string, userType string, uid string) (signedToken string, signedRefreshToken string, err error){
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
+
+// for how much duration the tokens will last.
+const (
+	AccessTokenTTL  = 24 * time.Hour
+	RefreshTokenTTL = 168 * time.Hour
+)
+
+// the tokens are tied to the session, and the refresh token carries refreshId as its jti,
+// which is swapped on every refresh (see RotateRefreshToken).
+func GenerateAllTokens(email string, firstName string, lastName string, userType string, uid string, session models.Session, refreshId string) (signedToken string, signedRefreshToken string, err error){
 	claims := &SignedDetails{
 		Email : email,
 		First_name: firstName,
 		Last_name: lastName,
 		Uid : uid,
 		User_type: userType,
+		Sid: session.ID,
+		Token_type: AccessToken,
+		Auth_time: session.Auth_time.Unix(),
+		Acr: session.Acr,
 		//for how much duration the token will last.
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			ExpiresAt: time.Now().Local().Add(AccessTokenTTL).Unix(),
 		},
 	}
 
 	//it is used to re assign the token after expiry.
 	refreshClaims := &SignedDetails{
+		Uid: uid,
+		Sid: session.ID,
+		Token_type: RefreshToken,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			Id: refreshId,
+			ExpiresAt: time.Now().Local().Add(RefreshTokenTTL).Unix(),
 			//The ExpiresAt field ensures that the token has a limited lifespan,
 			// enhancing security by forcing users to re-authenticate after the token expires.
 		},
@@ -113,47 +137,22 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string){
 	return claims, msg
 }
 
-func UpdateAllTokens(signedToken string, signedRefreshToken string, userId string){
+// RemoveStoredTokens clears the token and refresh_token the user documents used to keep,
+// the tokens live only with the client now and the sessions keep a hash of the refresh id.
+func RemoveStoredTokens(){
 	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-	var updateObj primitive.D
-	/*primitive.D is a type in the MongoDB Go driver that represents an ordered BSON document.
-	It is essentially a slice of key-value pairs and is used when you need to preserve the order of fields in a document,
-	which is important in certain MongoDB operations (e.g., $set, $push, etc.).*/
-
-
-	updateObj = append(updateObj, bson.E{Key: "token", Value: signedToken})
-	// bson.E: This represents a BSON element with a key-value pair.
-
-	updateObj = append(updateObj, bson.E{Key: "refresh_token", Value: signedRefreshToken})
-
-	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: Updated_at})
-
-
-	//upsert is set to true, meaning if no matching document is found,
-	//MongoDB will create a new document using the specified update object (updateObj).
-	upsert := true
-	filter := bson.M{"user_id":userId}
-
-	//This encapsulates(or contains) the upsert behavior for the update operation.
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-
-	_, err := userCollection.UpdateOne(
-		ctx,
-		filter,
-		bson.D{
-			{Key: "$set", Value: updateObj},
-		},
-		&opt,
-	)
-
 	defer cancel()
 
-	if err!=nil{
-		log.Panic(err)
+	filter := bson.M{"$or": []bson.M{
+		{"token": bson.M{"$exists": true}},
+		{"refresh_token": bson.M{"$exists": true}},
+	}}
+	result, err := userCollection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"token": "", "refresh_token": ""}})
+	if err != nil {
+		log.Printf("Failed to remove the stored tokens: %v", err)
 		return
 	}
-}
\ No newline at end of file
+	if result.ModifiedCount > 0 {
+		log.Printf("Removed the stored tokens of %d users", result.ModifiedCount)
+	}
+}
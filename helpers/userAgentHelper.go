@@ -0,0 +1,77 @@
+package helper
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DeviceInfo is the little we can read from a User-Agent header, good enough to show
+// "Chrome on Windows" in a login history.
+type DeviceInfo struct {
+	Browser     string `json:"browser" bson:"browser"`
+	Version     string `json:"browser_version" bson:"browser_version"`
+	OS          string `json:"os" bson:"os"`
+	Device_type string `json:"device_type" bson:"device_type"` // desktop, mobile, tablet or bot
+}
+
+func (d DeviceInfo) String() string {
+	return d.Browser + " on " + d.OS
+}
+
+// the order matters, Edge and Opera also say Chrome, and Chrome also says Safari.
+var browserPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/([\d.]+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/([\d.]+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+	{"Safari", regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+	{"curl", regexp.MustCompile(`curl/([\d.]+)`)},
+	{"Postman", regexp.MustCompile(`PostmanRuntime/([\d.]+)`)},
+}
+
+var osPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Windows", regexp.MustCompile(`Windows NT`)},
+	{"iOS", regexp.MustCompile(`iPhone|iPad|iPod`)},
+	{"Android", regexp.MustCompile(`Android`)},
+	{"macOS", regexp.MustCompile(`Mac OS X|Macintosh`)},
+	{"ChromeOS", regexp.MustCompile(`CrOS`)},
+	{"Linux", regexp.MustCompile(`Linux`)},
+}
+
+var botPattern = regexp.MustCompile(`(?i)bot|crawler|spider|slurp`)
+
+func ParseUserAgent(userAgent string) DeviceInfo {
+	info := DeviceInfo{Browser: "Unknown browser", OS: "Unknown OS", Device_type: "desktop"}
+
+	for _, b := range browserPatterns {
+		if match := b.pattern.FindStringSubmatch(userAgent); match != nil {
+			info.Browser = b.name
+			// the major version is enough.
+			info.Version = strings.SplitN(match[1], ".", 2)[0]
+			break
+		}
+	}
+	for _, o := range osPatterns {
+		if o.pattern.MatchString(userAgent) {
+			info.OS = o.name
+			break
+		}
+	}
+
+	switch {
+	case botPattern.MatchString(userAgent):
+		info.Device_type = "bot"
+	case strings.Contains(userAgent, "iPad") || (info.OS == "Android" && !strings.Contains(userAgent, "Mobile")):
+		info.Device_type = "tablet"
+	case strings.Contains(userAgent, "Mobile") || info.OS == "iOS":
+		info.Device_type = "mobile"
+	}
+	return info
+}
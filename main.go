@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"jwtauth/controllers"
+	helper "jwtauth/helpers"
+	"jwtauth/middleware"
 	routes "jwtauth/routes"
+	"jwtauth/services"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -22,11 +28,46 @@ func main(){
 		port = "8000"
 	}
 
+	// the queued emails are delivered in the background, by the transport set in MAIL_TRANSPORT.
+	go services.NewOutboxWorker(services.DefaultMailer()).Run(context.Background())
+
+	// the email and the phone are unique, whatever the timing of the requests. The phone numbers
+	// stored before they were kept in E.164 are normalized first, so the index compares the same form.
+	indexCtx, cancelIndex := context.WithTimeout(context.Background(), 100*time.Second)
+	if err := controllers.NormalizeStoredPhones(indexCtx); err != nil {
+		log.Printf("Failed to normalize the stored phone numbers: %v", err)
+	}
+	if err := controllers.EnsureIndexes(indexCtx); err != nil {
+		log.Printf("Failed to create the indexes: %v", err)
+	}
+	cancelIndex()
+
+	// the logins of unknown emails check against a dummy hash, it is made once here.
+	dummyCtx, cancelDummy := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := controllers.PrepareDummyPassword(dummyCtx); err != nil {
+		log.Printf("Failed to hash the dummy password: %v", err)
+	}
+	cancelDummy()
+
+	// the users used to keep their last tokens, they are cleared once at startup.
+	go helper.RemoveStoredTokens()
+
+	// the closed accounts are removed for good once their grace period is over.
+	go controllers.RunAccountPurger(context.Background())
+
 	router := gin.New()
+	// the client ip comes from the forwarded headers only when they are set by one of TRUSTED_PROXIES.
+	if err := router.SetTrustedProxies(services.TrustedProxies()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	router.Use(gin.Logger())
+	router.Use(middleware.RequestID())
 
 	// this is basically the routes that we are using, to find the information that we need.
+	// the admin group brings its own authentication, it goes before UserRoutes, whose Use would
+	// otherwise run Authenticate a second time in front of it.
 	routes.AuthRoutes(router)
+	routes.AdminRoutes(router)
 	routes.UserRoutes(router)
 
 	router.GET("/api-1", func (c *gin.Context)  {
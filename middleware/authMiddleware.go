@@ -1,15 +1,21 @@
 package middleware
 
 import(
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 	helper "jwtauth/helpers"
 	"github.com/gin-gonic/gin"
 )
 
 func Authenticate() gin.HandlerFunc{
 	return func(c *gin.Context){
+		// the token header, or the cookie for the browser clients that logged in with ?mode=cookie.
 		clientToken := c.Request.Header.Get("token")
+		if clientToken == "" {
+			clientToken, _ = c.Cookie(helper.AccessTokenCookie)
+		}
 		if clientToken == ""{
 			c.JSON(http.StatusInternalServerError, gin.H{"error":fmt.Sprintf("No Authorization header provided")})
 			c.Abort()
@@ -18,15 +24,44 @@ func Authenticate() gin.HandlerFunc{
 
 		claims, err := helper.ValidateToken(clientToken)
 		if err !="" {
+			helper.RecordAudit(c, helper.AuditTokenRejected, "", helper.AuditDenied, map[string]interface{}{"reason": err})
 			c.JSON(http.StatusInternalServerError, gin.H{"error":err})
 			c.Abort()
 			return
 		}
+		if claims.Token_type != helper.AccessToken || claims.Sid == "" {
+			helper.RecordAudit(c, helper.AuditTokenRejected, claims.Uid, helper.AuditDenied, map[string]interface{}{"reason": "not an access token"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not an access token, please login again"})
+			c.Abort()
+			return
+		}
+
+		// a revoked session takes its access tokens with it, even before they expire.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := helper.CheckSession(ctx, claims.Sid, claims.Uid); err != nil {
+			if err == helper.ErrAccountSuspended {
+				helper.RecordAudit(c, helper.AuditTokenRejected, claims.Uid, helper.AuditDenied, map[string]interface{}{"reason": "account suspended", "sid": claims.Sid})
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "account_suspended"})
+			} else if err == helper.ErrSessionRevoked {
+				helper.RecordAudit(c, helper.AuditTokenRejected, claims.Uid, helper.AuditDenied, map[string]interface{}{"reason": "session revoked", "sid": claims.Sid})
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the session"})
+			}
+			c.Abort()
+			return
+		}
+
 		c.Set("email", claims.Email)
 		c.Set("first_name", claims.First_name)
 		c.Set("last_name", claims.Last_name)
 		c.Set("uid",claims.Uid)
 		c.Set("user_type", claims.User_type)
+		c.Set("sid", claims.Sid)
+		c.Set("auth_time", claims.Auth_time)
+		c.Set("acr", claims.Acr)
+		c.Set("cookie_auth", c.Request.Header.Get("token") == "")
 		c.Next()
 	}
 }
\ No newline at end of file
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	helper "jwtauth/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSRF protects the state changing requests of the cookie clients with a double submit token:
+// the page has to copy the csrf_token cookie into the X-CSRF-Token header, which another site can't do.
+// Requests without the token cookies, or sending the token header, don't need it.
+func CSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if c.GetHeader("token") != "" || !hasSessionCookie(c) {
+			c.Next()
+			return
+		}
+
+		cookie, err := c.Cookie(helper.CSRFCookie)
+		header := c.GetHeader(helper.CSRFHeader)
+		if err != nil || cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+			helper.RecordAudit(c, helper.AuditTokenRejected, c.GetString("uid"), helper.AuditDenied, map[string]interface{}{"reason": "csrf token mismatch"})
+			c.JSON(http.StatusForbidden, gin.H{"error": "missing or invalid CSRF token", "code": "csrf_failed"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func hasSessionCookie(c *gin.Context) bool {
+	for _, name := range []string{helper.AccessTokenCookie, helper.RefreshTokenCookie} {
+		if value, err := c.Cookie(name); err == nil && value != "" {
+			return true
+		}
+	}
+	return false
+}
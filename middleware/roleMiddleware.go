@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+
+	helper "jwtauth/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireUserType lets only the given user type through, it replaces calling helper.CheckUserType
+// at the top of every handler of a route group. It goes after Authenticate.
+func RequireUserType(userType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, userType); err != nil {
+			helper.RecordAudit(c, helper.AuditAccessDenied, "", helper.AuditDenied, map[string]interface{}{"path": c.FullPath(), "required": userType})
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"time"
+
+	helper "jwtauth/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRecentAuth lets the request through only when the user logged in or reauthenticated
+// in the last maxAge, going by the auth_time claim. Otherwise the client gets a step_up_required
+// error and has to call users/reauthenticate first, then retry with the new token.
+// It goes after Authenticate.
+func RequireRecentAuth(maxAge time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !helper.RecentlyAuthenticated(c, maxAge) {
+			helper.RespondStepUpRequired(c, maxAge)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
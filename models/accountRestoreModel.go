@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the restore link of a closed account, valid until the account is purged. only the sha-256 of the token is stored.
+type AccountRestore struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	User_id    string             `bson:"user_id"`
+	Token_hash string             `bson:"token_hash"`
+	Expires_at time.Time          `bson:"expires_at"`
+	Created_at time.Time          `bson:"created_at"`
+}
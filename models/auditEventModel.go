@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// one entry of the security audit log, the entries are only ever inserted, never changed.
+type AuditEvent struct {
+	ID         primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	Timestamp  time.Time              `json:"timestamp" bson:"timestamp"`
+	Action     string                 `json:"action" bson:"action"`
+	Outcome    string                 `json:"outcome" bson:"outcome"`
+	Actor_id   string                 `json:"actor_id,omitempty" bson:"actor_id,omitempty"`   // who did it, the logged in user
+	Subject    string                 `json:"subject,omitempty" bson:"subject,omitempty"`     // who it was done to, a user_id or an email
+	IP         string                 `json:"ip" bson:"ip"`
+	User_agent string                 `json:"user_agent" bson:"user_agent"`
+	Request_id string                 `json:"request_id" bson:"request_id"`
+	Details    map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// a change of email waiting for the link sent to the new address, only the sha-256 of the token is stored.
+type EmailChange struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	User_id    string             `bson:"user_id"`
+	Old_email  string             `bson:"old_email"`
+	New_email  string             `bson:"new_email"`
+	Token_hash string             `bson:"token_hash"`
+	Expires_at time.Time          `bson:"expires_at"`
+	Created_at time.Time          `bson:"created_at"`
+}
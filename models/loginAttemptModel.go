@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// failed logins are counted per email, also for the emails that have no account,
+// so the lockout behaves the same either way.
+type LoginAttempt struct {
+	Email          string    `json:"email" bson:"email"`
+	Failed_count   int       `json:"failed_count" bson:"failed_count"`
+	Last_failed_at time.Time `json:"last_failed_at" bson:"last_failed_at"`
+	Locked_until   time.Time `json:"locked_until" bson:"locked_until"`
+}
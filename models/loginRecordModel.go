@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// one successful login, for the login history of the user.
+type LoginRecord struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	User_id     string             `json:"user_id" bson:"user_id"`
+	Timestamp   time.Time          `json:"timestamp" bson:"timestamp"`
+	IP          string             `json:"ip" bson:"ip"`
+	User_agent  string             `json:"user_agent" bson:"user_agent"`
+	Browser     string             `json:"browser" bson:"browser"`
+	OS          string             `json:"os" bson:"os"`
+	Device_type string             `json:"device_type" bson:"device_type"`
+	Device_hash string             `json:"-" bson:"device_hash"` // sha-256 of the device cookie
+	Country     string             `json:"country,omitempty" bson:"country,omitempty"`
+	City        string             `json:"city,omitempty" bson:"city,omitempty"`
+	New_device  bool               `json:"new_device" bson:"new_device"`
+}
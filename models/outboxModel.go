@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the states an email goes through in the outbox.
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+)
+
+// every email is first written here, the background worker picks it up and delivers it.
+type OutboxMessage struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	From            string             `json:"from" bson:"from"`
+	To              []string           `json:"to" bson:"to"`
+	Subject         string             `json:"subject" bson:"subject"`
+	HTML            string             `json:"-" bson:"html"`
+	Text            string             `json:"-" bson:"text"`
+	Status          string             `json:"status" bson:"status"`
+	Attempts        int                `json:"attempts" bson:"attempts"`
+	Last_error      string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	Next_attempt_at time.Time          `json:"next_attempt_at" bson:"next_attempt_at"`
+	Locked_until    time.Time          `json:"-" bson:"locked_until"`
+	Created_at      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at      time.Time          `json:"updated_at" bson:"updated_at"`
+	Sent_at         *time.Time         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// an old password hash of a user, kept to stop the same passwords coming back.
+type PasswordHistory struct {
+	User_id    string    `bson:"user_id"`
+	Hash       string    `bson:"hash"`
+	Created_at time.Time `bson:"created_at"`
+}
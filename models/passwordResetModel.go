@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// a forgot password request, only the sha-256 of the emailed token is stored.
+type PasswordReset struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	User_id    string             `bson:"user_id"`
+	Token_hash string             `bson:"token_hash"`
+	Expires_at time.Time          `bson:"expires_at"`
+	Created_at time.Time          `bson:"created_at"`
+}
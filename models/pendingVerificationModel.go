@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// a signup waits here until the email link is clicked, only then it is moved into the user collection.
+type PendingVerification struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	First_name     string             `bson:"first_name"`
+	Last_name      string             `bson:"last_name"`
+	Password       string             `bson:"password"`
+	Email          string             `bson:"email"`
+	Phone          string             `bson:"phone"`
+	User_type      string             `bson:"user_type"`
+	Language       string             `bson:"language"`
+	Verify_token   string             `bson:"verify_token"`
+	Verify_expires time.Time          `bson:"verify_expires"`
+	Created_at     time.Time          `bson:"created_at"`
+	// "link" or "code", in code mode only a hash of the 6 digit code is kept.
+	Verify_mode   string `bson:"verify_mode"`
+	Code_hash     string `bson:"code_hash,omitempty"`
+	Code_attempts int    `bson:"code_attempts"`
+	// counters for the resend cooldown and the daily cap, Sent_day is the UTC date of Sent_count.
+	Last_sent_at time.Time `bson:"last_sent_at"`
+	Sent_day     string    `bson:"sent_day"`
+	Sent_count   int       `bson:"sent_count"`
+}
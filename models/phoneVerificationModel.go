@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// a one time code sent by sms to confirm that the phone number belongs to the user.
+type PhoneVerification struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	User_id    string             `bson:"user_id"`
+	Phone      string             `bson:"phone"`
+	Code_hash  string             `bson:"code_hash"`
+	Attempts   int                `bson:"attempts"`
+	Expires_at time.Time          `bson:"expires_at"`
+	Created_at time.Time          `bson:"created_at"`
+	// the codes sent on Sent_day (a UTC date), for the daily cap.
+	Sent_day   string `bson:"sent_day"`
+	Sent_count int    `bson:"sent_count"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// one login of a user on one device, the tokens carry its id in the sid claim.
+// the refresh token is rotated on every use, only the latest one of the family is accepted.
+type Session struct {
+	ID             string     `json:"id" bson:"_id"`
+	User_id        string     `json:"user_id" bson:"user_id"`
+	User_type      string     `json:"user_type" bson:"user_type"` // picks the idle timeout and the lifetime
+	Refresh_family string     `json:"-" bson:"refresh_family"`
+	Refresh_hash   string     `json:"-" bson:"refresh_hash"` // sha-256 of the id of the refresh token handed out last
+	IP             string     `json:"ip" bson:"ip"`
+	User_agent     string     `json:"user_agent" bson:"user_agent"`
+	Browser        string     `json:"browser" bson:"browser"`
+	OS             string     `json:"os" bson:"os"`
+	Device_type    string     `json:"device_type" bson:"device_type"`
+	Auth_time      time.Time  `json:"auth_time" bson:"auth_time"` // the last time the user proved who they are, at login or reauthentication
+	Acr            string     `json:"acr" bson:"acr"`             // how they proved it
+	Country        string     `json:"country,omitempty" bson:"country,omitempty"`
+	City           string     `json:"city,omitempty" bson:"city,omitempty"`
+	Created_at     time.Time  `json:"created_at" bson:"created_at"`
+	Last_used_at   time.Time  `json:"last_used_at" bson:"last_used_at"`
+	Expires_at     time.Time  `json:"expires_at" bson:"expires_at"`         // pushed forward by every use, up to Max_expires_at
+	Max_expires_at time.Time  `json:"max_expires_at" bson:"max_expires_at"` // the absolute end, whatever the use
+	Revoked_at     *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	Revoked_reason string     `json:"revoked_reason,omitempty" bson:"revoked_reason,omitempty"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// the "somebody tried to sign up with your email" notices are counted per email,
+// so they go out under the same cooldown and daily cap as the verification emails.
+type SignupNotice struct {
+	Email        string    `json:"email" bson:"email"`
+	Last_sent_at time.Time `json:"last_sent_at" bson:"last_sent_at"`
+	Sent_day     string    `json:"sent_day" bson:"sent_day"`
+	Sent_count   int       `json:"sent_count" bson:"sent_count"`
+}
@@ -16,14 +16,28 @@ type User struct{
 	Last_name		*string					`json:"last_name" validate:"required,min=2,max=100"`
 	Password		*string					`json:"Password" validate:"required,min=6"`
 	Email			*string					`json:"email" validate:"email,required"`
-	Phone			*string					`json:"phone" validate:"required"`
-	Token			*string					`json:"token"`
+	Phone			*string					`json:"phone" validate:"required"`//stored in the E.164 format, like +916386402690.
+	PhoneVerified	bool					`json:"phone_verified" bson:"phone_verified"`
 	User_type		*string					`json:"user_type" validate:"required,eq=ADMIN|eq=USER"`//it is like enum validation in js, that only this particular type can access.
-	Refresh_token	*string					`json:"refresh_token"`
 	Created_at		time.Time				`json:"created_at"`
 	Updated_at		time.Time				`json:"updated_at"`
+	Password_changed_at	time.Time			`json:"password_changed_at" bson:"password_changed_at"`
 	User_id			string					`json:"user_id"`
 	IsVerified		bool					`json:"is_verified" bson:"is_verified"`
 	VerifyToken		*string					`json:"verify_token" bson:"verify_token"`
 	VerifyExpires	time.Time				`json:"verify_expires" bson:"verify_expires"`
-}
\ No newline at end of file
+	Language		*string					`json:"language" bson:"language" validate:"omitempty,max=35"`//preferred language for the emails, like "en" or "es-MX".
+	Password_reset_required	bool			`json:"password_reset_required" bson:"password_reset_required,omitempty"`//set by an admin, the login waits for the emailed reset.
+	Suspended_at	*time.Time				`json:"suspended_at" bson:"suspended_at,omitempty"`//set by an admin, the user can't login until reactivated or Suspended_until.
+	Suspended_until	*time.Time				`json:"suspended_until" bson:"suspended_until,omitempty"`//nil is until reactivated.
+	Suspension_reason	*string				`json:"suspension_reason" bson:"suspension_reason,omitempty"`
+	Deleted_at		*time.Time				`json:"deleted_at" bson:"deleted_at,omitempty"`//set when the user closed the account, it is purged after the grace period.
+}
+
+// IsSuspended tells if the suspension of the user is still running.
+func (user User) IsSuspended(now time.Time) bool {
+	if user.Suspended_at == nil {
+		return false
+	}
+	return user.Suspended_until == nil || now.Before(*user.Suspended_until)
+}
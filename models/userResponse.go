@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+)
+
+// UserResponse is what the API shows of a user. It is built field by field on purpose,
+// so the password hash, the tokens or the verification secrets never end up in a response
+// when a field is added to User.
+type UserResponse struct {
+	User_id                 string     `json:"user_id"`
+	First_name              *string    `json:"first_name"`
+	Last_name               *string    `json:"last_name"`
+	Email                   *string    `json:"email"`
+	Phone                   *string    `json:"phone"`
+	Phone_verified          bool       `json:"phone_verified"`
+	User_type               *string    `json:"user_type"`
+	Language                *string    `json:"language,omitempty"`
+	Is_verified             bool       `json:"is_verified"`
+	Created_at              time.Time  `json:"created_at"`
+	Updated_at              time.Time  `json:"updated_at"`
+	Password_reset_required bool       `json:"password_reset_required,omitempty"`
+	Suspended_at            *time.Time `json:"suspended_at,omitempty"`
+	Suspended_until         *time.Time `json:"suspended_until,omitempty"`
+	Suspension_reason       *string    `json:"suspension_reason,omitempty"`
+	Deleted_at              *time.Time `json:"deleted_at,omitempty"`
+}
+
+func NewUserResponse(user User) UserResponse {
+	return UserResponse{
+		User_id:                 user.User_id,
+		First_name:              user.First_name,
+		Last_name:               user.Last_name,
+		Email:                   user.Email,
+		Phone:                   user.Phone,
+		Phone_verified:          user.PhoneVerified,
+		User_type:               user.User_type,
+		Language:                user.Language,
+		Is_verified:             user.IsVerified,
+		Created_at:              user.Created_at,
+		Updated_at:              user.Updated_at,
+		Password_reset_required: user.Password_reset_required,
+		Suspended_at:            user.Suspended_at,
+		Suspended_until:         user.Suspended_until,
+		Suspension_reason:       user.Suspension_reason,
+		Deleted_at:              user.Deleted_at,
+	}
+}
+
+func NewUserResponses(users []User) []UserResponse {
+	responses := make([]UserResponse, 0, len(users))
+	for _, user := range users {
+		responses = append(responses, NewUserResponse(user))
+	}
+	return responses
+}
+
+// LoginResponse is the user with the tokens of the new session, the only place they are handed out
+// besides users/refresh.
+type LoginResponse struct {
+	UserResponse
+	Token         string `json:"token"`
+	Refresh_token string `json:"refresh_token"`
+}
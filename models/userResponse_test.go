@@ -0,0 +1,164 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the values of the secret fields, none of them may show up in a response.
+const (
+	secretPassword    = "$2a$14$secret-password-hash"
+	secretVerifyToken = "secret-verify-token"
+	secretRefreshHash = "secret-refresh-hash"
+	secretFamily      = "secret-refresh-family"
+	secretBody        = "secret-email-body"
+)
+
+func testUser() User {
+	str := func(s string) *string { return &s }
+	now := time.Now()
+	return User{
+		ID:                      primitive.NewObjectID(),
+		First_name:              str("Ada"),
+		Last_name:               str("Lovelace"),
+		Password:                str(secretPassword),
+		Email:                   str("ada@example.com"),
+		Phone:                   str("+916386402690"),
+		PhoneVerified:           true,
+		User_type:               str("USER"),
+		Created_at:              now,
+		Updated_at:              now,
+		Password_changed_at:     now,
+		User_id:                 "user-1",
+		IsVerified:              true,
+		VerifyToken:             str(secretVerifyToken),
+		VerifyExpires:           now,
+		Language:                str("en"),
+		Password_reset_required: true,
+		Suspended_at:            &now,
+		Suspended_until:         &now,
+		Suspension_reason:       str("spam"),
+		Deleted_at:              &now,
+	}
+}
+
+func marshalKeys(t *testing.T, v interface{}) (map[string]interface{}, string) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys map[string]interface{}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		t.Fatal(err)
+	}
+	return keys, string(data)
+}
+
+func assertNoSecrets(t *testing.T, keys map[string]interface{}, raw string, allowed ...string) {
+	t.Helper()
+	for key := range keys {
+		lower := strings.ToLower(key)
+		if strings.Contains(lower, "password") && lower != "password_reset_required" {
+			t.Errorf("response has the field %q", key)
+		}
+		if strings.Contains(lower, "verify") || strings.Contains(lower, "secret") || strings.Contains(lower, "hash") {
+			t.Errorf("response has the field %q", key)
+		}
+		if (strings.Contains(lower, "token") || strings.Contains(lower, "refresh")) && !contains(allowed, key) {
+			t.Errorf("response has the field %q", key)
+		}
+	}
+	for _, secret := range []string{secretPassword, secretVerifyToken, secretRefreshHash, secretFamily, secretBody} {
+		if strings.Contains(raw, secret) {
+			t.Errorf("response contains %q: %s", secret, raw)
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUserResponseHasNoSecrets(t *testing.T) {
+	keys, raw := marshalKeys(t, NewUserResponse(testUser()))
+	assertNoSecrets(t, keys, raw)
+	if keys["user_id"] != "user-1" || keys["email"] != "ada@example.com" {
+		t.Errorf("response lost the public fields: %s", raw)
+	}
+}
+
+func TestUserResponsesHaveNoSecrets(t *testing.T) {
+	data, err := json.Marshal(NewUserResponses([]User{testUser(), testUser()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []map[string]interface{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("got %d users, want 2", len(list))
+	}
+	for _, keys := range list {
+		assertNoSecrets(t, keys, string(data))
+	}
+}
+
+func TestLoginResponseOnlyAddsTheSessionTokens(t *testing.T) {
+	keys, raw := marshalKeys(t, LoginResponse{
+		UserResponse:  NewUserResponse(testUser()),
+		Token:         "access-token",
+		Refresh_token: "refresh-token",
+	})
+	assertNoSecrets(t, keys, raw, "token", "refresh_token")
+	if keys["token"] != "access-token" || keys["refresh_token"] != "refresh-token" {
+		t.Errorf("login response lost the tokens: %s", raw)
+	}
+}
+
+// a field added to UserResponse has to be added here too, after checking it is fine to show.
+func TestUserResponseFields(t *testing.T) {
+	want := []string{
+		"user_id", "first_name", "last_name", "email", "phone", "phone_verified", "user_type", "language",
+		"is_verified", "created_at", "updated_at", "password_reset_required", "suspended_at",
+		"suspended_until", "suspension_reason", "deleted_at",
+	}
+	typ := reflect.TypeOf(UserResponse{})
+	var got []string
+	for i := 0; i < typ.NumField(); i++ {
+		got = append(got, strings.Split(typ.Field(i).Tag.Get("json"), ",")[0])
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UserResponse fields = %v, want %v", got, want)
+	}
+}
+
+func TestSessionHidesTheRefreshToken(t *testing.T) {
+	keys, raw := marshalKeys(t, Session{
+		ID:             "session-1",
+		User_id:        "user-1",
+		Refresh_family: secretFamily,
+		Refresh_hash:   secretRefreshHash,
+	})
+	assertNoSecrets(t, keys, raw)
+}
+
+func TestOutboxMessageHidesTheBody(t *testing.T) {
+	keys, raw := marshalKeys(t, OutboxMessage{
+		To:   []string{"ada@example.com"},
+		HTML: secretBody,
+		Text: secretBody,
+	})
+	assertNoSecrets(t, keys, raw)
+}
@@ -0,0 +1,34 @@
+package routes
+
+import (
+	controller "jwtauth/controllers"
+	"jwtauth/middleware"
+	"jwtauth/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routes only for the ADMIN users. The group authenticates by itself, so it doesn't depend on
+// the order the routes are registered in, and every other user type gets a 403 from RequireUserType.
+func AdminRoutes(incomingRoutes *gin.Engine){
+	admin := incomingRoutes.Group("/admin",
+		middleware.Authenticate(),
+		middleware.CSRF(),
+		middleware.RequireUserType("ADMIN"))
+	admin.GET("/outbox", controller.GetOutboxMessages())
+	admin.POST("/outbox/:id/retry", controller.RetryOutboxMessage())
+	admin.GET("/metrics", controller.Metrics())
+	admin.GET("/audit", controller.GetAuditEvents())
+	admin.GET("/audit/export", controller.ExportAuditEvents())
+
+	// the user management, every action ends up in the audit log.
+	users := admin.Group("/users")
+	users.POST("", controller.CreateUser())
+	users.PATCH("/:user_id/role", controller.ChangeUserRole())
+	users.POST("/:user_id/force-password-reset", controller.ForcePasswordReset())
+	users.POST("/verify-email", controller.MarkEmailVerified())
+	users.POST("/:user_id/unlock", controller.UnlockUser())
+	users.POST("/:user_id/suspend", controller.SuspendUser())
+	users.POST("/:user_id/reactivate", controller.ReactivateUser())
+	users.DELETE("/:user_id", middleware.RequireRecentAuth(services.StepUpMaxAge()), controller.DeleteUser())
+}
@@ -2,6 +2,8 @@ package routes
 
 import (
 	controller "jwtauth/controllers"
+	"jwtauth/middleware"
+	"jwtauth/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,8 +11,45 @@ import (
 // this function is basically made for the authentication purpose, to signin or register the users.
 //and the signup and login functionality, will control by the controller package.
 
+// every auth route is throttled per ip, and the ones taking an email also per email,
+// the limits can be changed with the RATE_LIMIT_* env variables.
 func AuthRoutes(incomingRoutes *gin.Engine){
-	incomingRoutes.POST("users/signup", controller.Signup())
-	incomingRoutes.POST("users/login", controller.Login())
-	incomingRoutes.GET("users/verify-email", controller.VerifyEmail())
-}
\ No newline at end of file
+	incomingRoutes.POST("users/signup",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("signup_ip", "10/10m"), middleware.KeyByIP),
+		controller.Signup())
+	incomingRoutes.POST("users/login",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("login_ip", "20/1m"), middleware.KeyByIP),
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("login_email", "5/1m"), middleware.KeyByEmail),
+		controller.Login())
+	incomingRoutes.POST("users/refresh",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("refresh_ip", "60/1m"), middleware.KeyByIP),
+		middleware.CSRF(),
+		controller.RefreshToken())
+	incomingRoutes.GET("users/email/confirm",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("confirm_email_ip", "30/1m"), middleware.KeyByIP),
+		controller.ConfirmEmailChange())
+	incomingRoutes.GET("users/restore",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("restore_account_ip", "30/1m"), middleware.KeyByIP),
+		controller.RestoreAccount())
+	incomingRoutes.GET("users/verify-email",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("verify_email_ip", "30/1m"), middleware.KeyByIP),
+		controller.VerifyEmail())
+	incomingRoutes.POST("users/verify-email/resend",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("resend_ip", "10/10m"), middleware.KeyByIP),
+		controller.ResendVerificationEmail())
+	incomingRoutes.POST("users/verify-email/code",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("verify_code_ip", "30/1m"), middleware.KeyByIP),
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("verify_code_email", "10/10m"), middleware.KeyByEmail),
+		controller.VerifyEmailCode())
+	incomingRoutes.POST("users/password/forgot",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("forgot_password_ip", "10/10m"), middleware.KeyByIP),
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("forgot_password_email", "3/1h"), middleware.KeyByEmail),
+		controller.ForgotPassword())
+	incomingRoutes.POST("users/password/reset",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("reset_password_ip", "10/10m"), middleware.KeyByIP),
+		controller.ResetPassword())
+	incomingRoutes.POST("users/password/change-expired",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("login_ip", "20/1m"), middleware.KeyByIP),
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("login_email", "5/1m"), middleware.KeyByEmail),
+		controller.ChangeExpiredPassword())
+}
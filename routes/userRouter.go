@@ -3,6 +3,7 @@ package routes
 import(
 	controller "jwtauth/controllers"
 	"jwtauth/middleware"
+	"jwtauth/services"
 	"github.com/gin-gonic/gin"
 )
 
@@ -11,6 +12,31 @@ func UserRoutes(incomingRoutes *gin.Engine){
 	// we are using middleware, because after login the token is generated, and the token determines who have 
 	//how much authority in the database to access, which is held on middleware folder.
 	incomingRoutes.Use(middleware.Authenticate())
+	// the cookie clients have to send the csrf token on anything that changes data.
+	incomingRoutes.Use(middleware.CSRF())
 	incomingRoutes.GET("/users", controller.GetUsers())
 	incomingRoutes.GET("/users/:user_id", controller.GetUser())
+	incomingRoutes.GET("/users/:user_id/logins", controller.GetLoginHistory())
+	incomingRoutes.PATCH("/users/:user_id",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("update_user", "20/10m"), middleware.KeyByUserID),
+		controller.UpdateUser())
+	incomingRoutes.DELETE("/users/:user_id",
+		middleware.RequireRecentAuth(services.StepUpMaxAge()),
+		controller.DeleteUser())
+	incomingRoutes.POST("/users/phone/send-otp",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("phone_otp_user", "5/1h"), middleware.KeyByUserID),
+		controller.SendPhoneOTP())
+	incomingRoutes.POST("/users/phone/verify",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("phone_verify_user", "10/10m"), middleware.KeyByUserID),
+		controller.ConfirmPhoneOTP())
+	incomingRoutes.POST("/users/reauthenticate",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("reauthenticate_user", "5/10m"), middleware.KeyByUserID),
+		controller.Reauthenticate())
+	// the sensitive routes want a login or reauthentication from the last STEP_UP_MAX_AGE.
+	incomingRoutes.POST("/users/me/password",
+		middleware.RateLimit(services.RateLimitPolicyFromEnv("change_password_user", "5/10m"), middleware.KeyByUserID),
+		middleware.RequireRecentAuth(services.StepUpMaxAge()),
+		controller.ChangePassword())
+	incomingRoutes.GET("/users/me/sessions", controller.GetSessions())
+	incomingRoutes.DELETE("/users/me/sessions/:id", controller.RevokeSession())
 }
@@ -0,0 +1,16 @@
+package services
+
+import (
+	"time"
+)
+
+// a closed account can be restored for ACCOUNT_DELETION_GRACE, then it is purged by a job
+// running every ACCOUNT_PURGE_INTERVAL.
+
+func AccountDeletionGrace() time.Duration {
+	return envDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour)
+}
+
+func AccountPurgeInterval() time.Duration {
+	return envDuration("ACCOUNT_PURGE_INTERVAL", time.Hour)
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the breached password list is read from BREACHED_PASSWORDS_PATH, in the Have I Been Pwned format:
+//   - a directory of range files, one per 5 character SHA-1 prefix (like "5BAA6" or "5BAA6.txt"),
+//     with "SUFFIX:COUNT" lines, only the one file for the prefix is read on every check.
+//   - or a single file with "SHA1:COUNT" lines, it is loaded into memory once, so keep it small.
+// BREACHED_PASSWORD_MIN_COUNT ignores the hashes seen fewer times than that.
+
+var (
+	breachedOnce   sync.Once
+	breachedHashes map[string]int
+	breachedDir    string
+)
+
+func loadBreachedPasswords() {
+	path := os.Getenv("BREACHED_PASSWORDS_PATH")
+	if path == "" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("Failed to open the breached password list: %v", err)
+		return
+	}
+	if info.IsDir() {
+		breachedDir = path
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to open the breached password list: %v", err)
+		return
+	}
+	defer file.Close()
+
+	breachedHashes = map[string]int{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash, count := parseBreachedLine(scanner.Text())
+		if len(hash) == 40 {
+			breachedHashes[hash] = count
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		log.Printf("Failed to read the breached password list: %v", err)
+	}
+	log.Printf("Loaded %d breached password hashes", len(breachedHashes))
+}
+
+func parseBreachedLine(line string) (string, int) {
+	parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+	hash := strings.ToUpper(parts[0])
+	count := 1
+	if len(parts) == 2 {
+		if n, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil {
+			count = n
+		}
+	}
+	return hash, count
+}
+
+// IsBreachedPassword checks the password against the local list, without a list it is never breached.
+func IsBreachedPassword(password string) (bool, error) {
+	breachedOnce.Do(loadBreachedPasswords)
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	minCount := envInt("BREACHED_PASSWORD_MIN_COUNT", 1)
+
+	if breachedHashes != nil {
+		return breachedHashes[hash] >= minCount, nil
+	}
+	if breachedDir == "" {
+		return false, nil
+	}
+
+	prefix, suffix := hash[:5], hash[5:]
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		file, err := os.Open(filepath.Join(breachedDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lineSuffix, count := parseBreachedLine(scanner.Text())
+			if lineSuffix == suffix {
+				return count >= minCount, nil
+			}
+		}
+		return false, scanner.Err()
+	}
+	return false, nil
+}
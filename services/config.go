@@ -0,0 +1,57 @@
+package services
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// small helpers to read the optional settings from the env, with a default when they are not set.
+
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// SecureCookies marks the cookies we set as Secure, turn it off with COOKIE_SECURE=false for local http.
+func SecureCookies() bool {
+	return os.Getenv("COOKIE_SECURE") != "false"
+}
+
+// TrustedProxies is the list of proxies (ips or cidrs, comma separated in TRUSTED_PROXIES) whose
+// X-Forwarded-For and X-Real-IP headers are believed. It is empty by default, then the client ip is
+// always the address of the connection, because anybody can send those headers and pick the ip
+// the rate limits, the lockout, the audit log and the login history see. Behind a load balancer
+// set it to the address of the load balancer, e.g. TRUSTED_PROXIES=10.0.0.0/8.
+func TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
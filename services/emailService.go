@@ -1,65 +1,208 @@
 package services
 
 import (
-	"fmt"
-	"net/smtp"
+	"context"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+
+	"jwtauth/models"
 )
 
 type EmailService struct {
-	fromEmail    string
-	fromPassword string
-	smtpHost     string
-	smtpPort     string
+	fromEmail string
+	mailer    Mailer
 }
 
+// NewEmailService queues the emails in the outbox, the background worker delivers them.
+// With EMAIL_DELIVERY=direct they are sent right away with the configured transport.
 func NewEmailService() *EmailService {
+	if strings.ToLower(os.Getenv("EMAIL_DELIVERY")) == "direct" {
+		return NewEmailServiceWithMailer(DefaultMailer())
+	}
+	return NewEmailServiceWithMailer(NewOutbox())
+}
+
+func NewEmailServiceWithMailer(mailer Mailer) *EmailService {
 	return &EmailService{
-		fromEmail:    os.Getenv("EMAIL_FROM"),
-		fromPassword: os.Getenv("EMAIL_PASSWORD"),
-		smtpHost:     os.Getenv("SMTP_HOST"),
-		smtpPort:     os.Getenv("SMTP_PORT"),
+		fromEmail: os.Getenv("EMAIL_FROM"),
+		mailer:    mailer,
+	}
+}
+
+// PublicBaseURL is where the api can be reached from outside, links in the emails are built on it.
+func PublicBaseURL() string {
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8000"
+	}
+	return "http://localhost:" + port
+}
+
+// send renders the named template in the user's language and hands the message to the mailer.
+func (s *EmailService) send(toEmail string, locale string, name string, data interface{}) error {
+	subject, html, text, err := RenderEmail(locale, name, data)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.mailer.Send(ctx, Message{
+		From:    s.fromEmail,
+		To:      []string{toEmail},
+		Subject: subject,
+		HTML:    html,
+		Text:    text,
+	})
+}
+
+func (s *EmailService) SendVerificationEmail(toEmail string, name string, locale string, verifyToken string) error {
+	return s.send(toEmail, locale, "verify_email", map[string]interface{}{
+		"Name":           name,
+		"Link":           PublicBaseURL() + "/users/verify-email?token=" + url.QueryEscape(verifyToken),
+		"ExpiresInHours": int(VerificationTTL.Hours()),
+	})
+}
+
+func (s *EmailService) SendVerificationCode(toEmail string, name string, locale string, code string) error {
+	return s.send(toEmail, locale, "verify_code", map[string]interface{}{
+		"Name":             name,
+		"Code":             code,
+		"ExpiresInMinutes": int(VerificationCodeTTL().Minutes()),
+	})
+}
+
+func (s *EmailService) SendLockoutNotice(toEmail string, name string, locale string, lockedFor time.Duration) error {
+	return s.send(toEmail, locale, "account_locked", map[string]interface{}{
+		"Name":          name,
+		"LockedMinutes": int(lockedFor.Minutes()),
+	})
+}
+
+func (s *EmailService) SendAccountExistsNotice(toEmail string, name string, locale string) error {
+	return s.send(toEmail, locale, "account_exists", map[string]interface{}{
+		"Name": name,
+	})
+}
+
+// ConcealExistingAccounts makes Signup answer the same for taken and new emails, see SIGNUP_CONCEAL_EXISTING.
+func ConcealExistingAccounts() bool {
+	return strings.ToLower(os.Getenv("SIGNUP_CONCEAL_EXISTING")) == "true"
+}
+
+// EmailChangeTTL is how long the link confirming a new email stays valid.
+func EmailChangeTTL() time.Duration {
+	return envDuration("EMAIL_CHANGE_TTL", 24*time.Hour)
+}
+
+// PasswordResetTTL is how long a forgot password link stays valid.
+func PasswordResetTTL() time.Duration {
+	return envDuration("PASSWORD_RESET_TTL", time.Hour)
+}
+
+// the reset link normally opens a page of the frontend, PASSWORD_RESET_URL points to it.
+func passwordResetURL(token string) string {
+	base := os.Getenv("PASSWORD_RESET_URL")
+	if base == "" {
+		base = PublicBaseURL() + "/users/password/reset"
+	}
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
 	}
+	return base + separator + "token=" + url.QueryEscape(token)
 }
 
-func (s *EmailService) SendVerificationEmail(toEmail string, verifyToken string) error {
-	auth := smtp.PlainAuth("", s.fromEmail, s.fromPassword, s.smtpHost)
-	
-	// Create verification link
-	verifyLink := fmt.Sprintf("http://localhost:8000/verify-email?token=%s", verifyToken)
-	
-	// Email content
-	subject := "Email Verification"
-	body := fmt.Sprintf(`
-		<html>
-			<body>
-				<h2>Email Verification</h2>
-				<p>Please click the link below to verify your email address:</p>
-				<p><a href="%s">Verify Email</a></p>
-				<p>This link will expire in 24 hours.</p>
-				<p>If you did not request this verification, please ignore this email.</p>
-			</body>
-		</html>
-	`, verifyLink)
-
-	msg := fmt.Sprintf("To: %s\r\n"+
-		"Subject: %s\r\n"+
-		"MIME-Version: 1.0\r\n"+
-		"Content-Type: text/html; charset=UTF-8\r\n"+
-		"\r\n"+
-		"%s", toEmail, subject, body)
-
-	addr := fmt.Sprintf("%s:%s", s.smtpHost, s.smtpPort)
-	return smtp.SendMail(addr, auth, s.fromEmail, []string{toEmail}, []byte(msg))
+func (s *EmailService) SendPasswordResetEmail(toEmail string, name string, locale string, token string) error {
+	return s.send(toEmail, locale, "password_reset", map[string]interface{}{
+		"Name":             name,
+		"Link":             passwordResetURL(token),
+		"ExpiresInMinutes": int(PasswordResetTTL().Minutes()),
+	})
+}
+
+func (s *EmailService) SendEmailChangeVerification(toEmail string, name string, locale string, token string) error {
+	return s.send(toEmail, locale, "email_change_verify", map[string]interface{}{
+		"Name":             name,
+		"Link":             PublicBaseURL() + "/users/email/confirm?token=" + url.QueryEscape(token),
+		"ExpiresInMinutes": int(EmailChangeTTL().Minutes()),
+	})
+}
+
+// SendEmailChangeNotice goes to the old address, in case the change wasn't asked by the owner.
+func (s *EmailService) SendEmailChangeNotice(toEmail string, name string, locale string, newEmail string) error {
+	return s.send(toEmail, locale, "email_change_notice", map[string]interface{}{
+		"Name":     name,
+		"NewEmail": newEmail,
+	})
+}
+
+func (s *EmailService) SendAccountDeletedNotice(toEmail string, name string, locale string, token string, purgeAt time.Time) error {
+	return s.send(toEmail, locale, "account_deleted", map[string]interface{}{
+		"Name":      name,
+		"Link":      PublicBaseURL() + "/users/restore?token=" + url.QueryEscape(token),
+		"GraceDays": int(AccountDeletionGrace().Hours() / 24),
+		"PurgeAt":   purgeAt.UTC().Format("2006-01-02"),
+	})
+}
+
+// SendSuspensionNotice tells the user why the account was suspended, until is nil when it has no end.
+func (s *EmailService) SendSuspensionNotice(toEmail string, name string, locale string, reason string, until *time.Time) error {
+	data := map[string]interface{}{
+		"Name":   name,
+		"Reason": reason,
+	}
+	if until != nil {
+		data["Until"] = until.UTC().Format("2006-01-02 15:04 MST")
+	}
+	return s.send(toEmail, locale, "account_suspended", data)
+}
+
+func (s *EmailService) SendReactivationNotice(toEmail string, name string, locale string) error {
+	return s.send(toEmail, locale, "account_reactivated", map[string]interface{}{
+		"Name": name,
+	})
+}
+
+func (s *EmailService) SendPasswordChangedNotice(toEmail string, name string, locale string) error {
+	return s.send(toEmail, locale, "password_changed", map[string]interface{}{
+		"Name": name,
+	})
+}
+
+func (s *EmailService) SendNewDeviceNotice(toEmail string, name string, locale string, login models.LoginRecord) error {
+	location := login.City
+	if login.Country != "" {
+		if location != "" {
+			location += ", "
+		}
+		location += login.Country
+	}
+	return s.send(toEmail, locale, "new_device", map[string]interface{}{
+		"Name":     name,
+		"Time":     login.Timestamp.Format("2006-01-02 15:04 MST"),
+		"Browser":  login.Browser,
+		"OS":       login.OS,
+		"IP":       login.IP,
+		"Location": location,
+	})
 }
 
 func GenerateVerificationToken() string {
 	return uuid.New().String()
 }
 
+// how long a verification link stays valid.
+const VerificationTTL = 24 * time.Hour
+
 func GetVerificationExpiryTime() time.Time {
-	return time.Now().Add(24 * time.Hour)
-} 
\ No newline at end of file
+	return time.Now().Add(VerificationTTL)
+}
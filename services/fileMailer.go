@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer writes every message into a directory instead of sending it.
+// By default it writes plain .eml files, with Maildir set it uses the tmp/new/cur layout,
+// so a mail client can open the directory directly.
+type FileMailer struct {
+	Dir     string
+	Maildir bool
+}
+
+func NewFileMailer(dir string, maildir bool) (*FileMailer, error) {
+	m := &FileMailer{Dir: dir, Maildir: maildir}
+
+	dirs := []string{dir}
+	if maildir {
+		dirs = []string{filepath.Join(dir, "tmp"), filepath.Join(dir, "new"), filepath.Join(dir, "cur")}
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	name := fmt.Sprintf("%d-%s", time.Now().UnixNano(), uuid.New().String())
+
+	if !m.Maildir {
+		return os.WriteFile(filepath.Join(m.Dir, name+".eml"), msg.Bytes(), 0o644)
+	}
+
+	// maildir: write into tmp first and then move into new, so readers never see half a message.
+	tmpPath := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, msg.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(m.Dir, "new", name))
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GeoLocation is a coarse location, the country and the city at most.
+type GeoLocation struct {
+	Country_code string `json:"country_code,omitempty" bson:"country_code,omitempty"`
+	Country      string `json:"country,omitempty" bson:"country,omitempty"`
+	City         string `json:"city,omitempty" bson:"city,omitempty"`
+}
+
+// the GeoIP database is a csv file at GEOIP_DB_PATH, loaded once into memory, with lines like
+//
+//	network,country_code,country,city
+//	1.2.3.0/24,IN,India,Mumbai
+//
+// the networks must not overlap, the GeoLite2 / IP2Location lite csv exports can be turned into it.
+type geoRange struct {
+	start    net.IP
+	end      net.IP
+	location GeoLocation
+}
+
+var (
+	geoOnce   sync.Once
+	geoRanges []geoRange
+)
+
+func loadGeoIP() {
+	path := os.Getenv("GEOIP_DB_PATH")
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to open the GeoIP database: %v", err)
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to read the GeoIP database: %v", err)
+			return
+		}
+		if len(record) < 2 {
+			continue
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(record[0]))
+		if err != nil {
+			// the header line, or a broken one.
+			continue
+		}
+
+		start := network.IP.To16()
+		end := make(net.IP, len(start))
+		ones, bits := network.Mask.Size()
+		// ipv4 networks are kept as ipv4-mapped ipv6, so both kinds sort in the same list.
+		mask := net.CIDRMask(ones+128-bits, 128)
+		for i := range start {
+			end[i] = start[i] | ^mask[i]
+		}
+
+		location := GeoLocation{Country_code: strings.TrimSpace(record[1])}
+		if len(record) > 2 {
+			location.Country = strings.TrimSpace(record[2])
+		}
+		if len(record) > 3 {
+			location.City = strings.TrimSpace(record[3])
+		}
+		geoRanges = append(geoRanges, geoRange{start: start, end: end, location: location})
+	}
+
+	sort.Slice(geoRanges, func(i, j int) bool { return bytes.Compare(geoRanges[i].start, geoRanges[j].start) < 0 })
+	log.Printf("Loaded %d GeoIP networks", len(geoRanges))
+}
+
+// LookupIP finds the location of the ip, ok is false without a database or for an unknown ip.
+func LookupIP(ip string) (GeoLocation, bool) {
+	geoOnce.Do(loadGeoIP)
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil || len(geoRanges) == 0 {
+		return GeoLocation{}, false
+	}
+	parsed = parsed.To16()
+
+	// the last network that starts at or before the ip.
+	i := sort.Search(len(geoRanges), func(i int) bool { return bytes.Compare(geoRanges[i].start, parsed) > 0 }) - 1
+	if i < 0 || bytes.Compare(parsed, geoRanges[i].end) > 0 {
+		return GeoLocation{}, false
+	}
+	return geoRanges[i].location, true
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPMailerSend(t *testing.T) {
+	var got httpMailRequest
+	var auth, contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		auth = r.Header.Get("Authorization")
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding the request: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	msg := Message{
+		From:    "noreply@example.com",
+		To:      []string{"user@example.com"},
+		Subject: "Verify your email",
+		HTML:    "<p>hello</p>",
+		Text:    "hello",
+	}
+	if err := NewHTTPMailer(server.URL, "secret-key").Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if auth != "Bearer secret-key" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	if got.From != msg.From || len(got.To) != 1 || got.To[0] != msg.To[0] || got.Subject != msg.Subject ||
+		got.HTML != msg.HTML || got.Text != msg.Text {
+		t.Errorf("payload = %+v, want %+v", got, msg)
+	}
+}
+
+func TestHTTPMailerNoAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Header["Authorization"]; ok {
+			t.Errorf("Authorization header sent without an api key")
+		}
+	}))
+	defer server.Close()
+
+	if err := NewHTTPMailer(server.URL, "").Send(context.Background(), Message{To: []string{"user@example.com"}}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+}
+
+func TestHTTPMailerErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid recipient", http.StatusUnprocessableEntity)
+	}))
+	defer server.Close()
+
+	err := NewHTTPMailer(server.URL, "").Send(context.Background(), Message{To: []string{"user@example.com"}})
+	if err == nil {
+		t.Fatal("Send succeeded on a 422")
+	}
+	if !strings.Contains(err.Error(), "422") || !strings.Contains(err.Error(), "invalid recipient") {
+		t.Errorf("error = %q, want the status and the body", err)
+	}
+}
+
+func TestHTTPMailerCanceledContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewHTTPMailer(server.URL, "").Send(ctx, Message{To: []string{"user@example.com"}}); err == nil {
+		t.Fatal("Send succeeded with a canceled context")
+	}
+}
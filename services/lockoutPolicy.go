@@ -0,0 +1,38 @@
+package services
+
+import (
+	"time"
+)
+
+// after LOCKOUT_DELAY_AFTER failed logins every new try has to wait a bit longer,
+// after LOCKOUT_THRESHOLD of them the account is locked for LOCKOUT_DURATION.
+
+func LockoutThreshold() int {
+	return envInt("LOCKOUT_THRESHOLD", 10)
+}
+
+func LockoutDuration() time.Duration {
+	return envDuration("LOCKOUT_DURATION", 15*time.Minute)
+}
+
+// LockoutWindow is how long the failures are counted, the count starts again after a pause this long.
+func LockoutWindow() time.Duration {
+	return envDuration("LOCKOUT_WINDOW", time.Hour)
+}
+
+// LoginDelay is how long to wait after the last failure, it doubles with every failure past the start.
+func LoginDelay(failedCount int) time.Duration {
+	start := envInt("LOCKOUT_DELAY_AFTER", 3)
+	if failedCount < start {
+		return 0
+	}
+	delay := envDuration("LOCKOUT_DELAY_BASE", time.Second)
+	max := envDuration("LOCKOUT_DELAY_MAX", 30*time.Second)
+	for i := start; i < failedCount && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is a single email, it is transport independent so the same message can go
+// to smtp, to a file on disk or just into memory.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Mailer is implemented by every email transport.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var (
+	defaultMailer Mailer
+	mailerMu      sync.Mutex
+)
+
+// DefaultMailer returns the transport selected by MAIL_TRANSPORT, it is built only once.
+func DefaultMailer() Mailer {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+
+	if defaultMailer == nil {
+		mailer, err := NewMailerFromEnv()
+		if err != nil {
+			log.Printf("Invalid mail configuration, falling back to the log transport: %v", err)
+			mailer = NewLogMailer()
+		}
+		defaultMailer = mailer
+	}
+	return defaultMailer
+}
+
+// SetDefaultMailer replaces the transport, for example with a MemoryMailer in tests.
+func SetDefaultMailer(mailer Mailer) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	defaultMailer = mailer
+}
+
+// NewMailerFromEnv builds the transport named in MAIL_TRANSPORT (smtp, file, memory, http or log).
+func NewMailerFromEnv() (Mailer, error) {
+	transport := strings.ToLower(os.Getenv("MAIL_TRANSPORT"))
+
+	switch transport {
+	case "", "smtp":
+		return NewSMTPMailerFromEnv()
+	case "file":
+		dir := os.Getenv("MAIL_FILE_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileMailer(dir, strings.ToLower(os.Getenv("MAIL_FILE_FORMAT")) == "maildir")
+	case "memory":
+		return NewMemoryMailer(), nil
+	case "http":
+		endpoint := os.Getenv("MAIL_HTTP_ENDPOINT")
+		if endpoint == "" {
+			return nil, fmt.Errorf("MAIL_HTTP_ENDPOINT is required for the http transport")
+		}
+		return NewHTTPMailer(endpoint, os.Getenv("MAIL_HTTP_API_KEY")), nil
+	case "log":
+		return NewLogMailer(), nil
+	}
+	return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", transport)
+}
+
+// Bytes renders the message in the RFC 5322 format, which is what smtp and .eml files expect.
+func (m Message) Bytes() []byte {
+	var buf bytes.Buffer
+
+	to := make([]string, 0, len(m.To))
+	for _, address := range m.To {
+		to = append(to, headerAddress(address))
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", headerAddress(m.From))
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", uuid.New().String(), messageIDDomain(m.From))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	switch {
+	case m.HTML != "" && m.Text != "":
+		// both versions go out as multipart/alternative, clients show the last part they can render.
+		mw := multipart.NewWriter(&buf)
+		fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+		writeMIMEPart(mw, "text/plain; charset=UTF-8", m.Text)
+		writeMIMEPart(mw, "text/html; charset=UTF-8", m.HTML)
+		mw.Close()
+	case m.HTML != "":
+		buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuotedPrintable(&buf, m.HTML)
+	default:
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuotedPrintable(&buf, m.Text)
+	}
+	return buf.Bytes()
+}
+
+// headerAddress formats an address like "Name <user@example.com>" for a header, the name is
+// encoded when it isn't plain ascii. A line break can never get through, so nothing can add
+// its own headers (a Bcc for example) with a crafted name or address.
+func headerAddress(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return parsed.String()
+	}
+	return strings.NewReplacer("\r", "", "\n", "").Replace(address)
+}
+
+func writeMIMEPart(mw *multipart.Writer, contentType string, body string) {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	part, _ := mw.CreatePart(header)
+	writeQuotedPrintable(part, body)
+}
+
+func writeQuotedPrintable(w io.Writer, body string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(body))
+	qp.Close()
+}
+
+func messageIDDomain(from string) string {
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		return strings.Trim(from[at+1:], "> ")
+	}
+	return "localhost"
+}
+
+// LogMailer only prints the message, handy in local development.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (l *LogMailer) Send(ctx context.Context, msg Message) error {
+	body := msg.Text
+	if body == "" {
+		body = msg.HTML
+	}
+	log.Printf("email to %s, subject %q:\n%s", strings.Join(msg.To, ", "), msg.Subject, body)
+	return nil
+}
+
+// MemoryMailer keeps every message it gets, so tests can look at what was sent.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of everything sent so far.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parseMessage(t *testing.T, raw []byte) *mail.Message {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v\n%s", err, raw)
+	}
+	return msg
+}
+
+func decodeHeader(t *testing.T, value string) string {
+	t.Helper()
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		t.Fatalf("DecodeHeader(%q): %v", value, err)
+	}
+	return decoded
+}
+
+func TestMessageBytesHeaderInjection(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  Message
+	}{
+		{"subject", Message{From: "noreply@example.com", To: []string{"user@example.com"}, Subject: "Hello\r\nBcc: evil@example.com"}},
+		{"from name", Message{From: "Team\r\nBcc: evil@example.com <noreply@example.com>", To: []string{"user@example.com"}, Subject: "Hello"}},
+		{"to", Message{From: "noreply@example.com", To: []string{"user@example.com\r\nBcc: evil@example.com"}, Subject: "Hello"}},
+		{"bare newline", Message{From: "noreply@example.com", To: []string{"user@example.com"}, Subject: "Hello\nX-Injected: 1"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.msg.Text = "body"
+			msg := parseMessage(t, tc.msg.Bytes())
+			for _, header := range []string{"Bcc", "X-Injected"} {
+				if value := msg.Header.Get(header); value != "" {
+					t.Errorf("injected %s header: %q", header, value)
+				}
+			}
+			body, _ := io.ReadAll(msg.Body)
+			if strings.Contains(string(body), "evil@example.com") && tc.name != "to" {
+				t.Errorf("injected text ended up in the body: %q", body)
+			}
+		})
+	}
+}
+
+func TestMessageBytesEncodesHeaders(t *testing.T) {
+	msg := parseMessage(t, Message{
+		From:    "Équipe Support <noreply@example.com>",
+		To:      []string{"user@example.com", "other@example.com"},
+		Subject: "Vérifiez votre adresse",
+		Text:    "bonjour",
+	}.Bytes())
+
+	if got := decodeHeader(t, msg.Header.Get("Subject")); got != "Vérifiez votre adresse" {
+		t.Errorf("Subject = %q", got)
+	}
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Name != "Équipe Support" || from[0].Address != "noreply@example.com" {
+		t.Errorf("From = %v, %v", from, err)
+	}
+	to, err := msg.Header.AddressList("To")
+	if err != nil || len(to) != 2 || to[0].Address != "user@example.com" || to[1].Address != "other@example.com" {
+		t.Errorf("To = %v, %v", to, err)
+	}
+	if msg.Header.Get("Message-ID") == "" || msg.Header.Get("Date") == "" {
+		t.Errorf("missing Message-ID or Date: %v", msg.Header)
+	}
+}
+
+func TestMessageBytesMultipart(t *testing.T) {
+	msg := parseMessage(t, Message{
+		From:    "noreply@example.com",
+		To:      []string{"user@example.com"},
+		Subject: "Hello",
+		Text:    "plain = text",
+		HTML:    "<p>html = text</p>",
+	}.Bytes())
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", msg.Header.Get("Content-Type"), err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	want := []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", "plain = text"},
+		{"text/html; charset=UTF-8", "<p>html = text</p>"},
+	}
+	for _, w := range want {
+		part, err := reader.NextRawPart()
+		if err != nil {
+			t.Fatalf("NextRawPart: %v", err)
+		}
+		if got := part.Header.Get("Content-Type"); got != w.contentType {
+			t.Errorf("part Content-Type = %q, want %q", got, w.contentType)
+		}
+		body, _ := io.ReadAll(quotedprintable.NewReader(part))
+		if string(body) != w.body {
+			t.Errorf("part body = %q, want %q", body, w.body)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("more than two parts: %v", err)
+	}
+}
+
+func TestFileMailerWritesEml(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewFileMailer(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mailer.Send(context.Background(), Message{
+		From: "noreply@example.com", To: []string{"user@example.com"}, Subject: "Hello", Text: "the body",
+	}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("got %d .eml files, want 1", len(files))
+	}
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := parseMessage(t, raw)
+	if msg.Header.Get("Subject") != "Hello" {
+		t.Errorf("Subject = %q", msg.Header.Get("Subject"))
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if string(body) != "the body" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestFileMailerMaildir(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewFileMailer(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mailer.Send(context.Background(), Message{To: []string{"user@example.com"}, Subject: "Hello"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	for sub, want := range map[string]int{"new": 1, "tmp": 0, "cur": 0} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != want {
+			t.Errorf("%s has %d messages, want %d", sub, len(entries), want)
+		}
+	}
+}
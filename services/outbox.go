@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"jwtauth/database"
+	"jwtauth/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var outboxCollection *mongo.Collection = database.OpenCollection(database.Client, "email_outbox")
+
+// Outbox is a Mailer that only stores the message, so a request never waits for the smtp server.
+type Outbox struct{}
+
+func NewOutbox() *Outbox {
+	return &Outbox{}
+}
+
+func (o *Outbox) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	_, err := outboxCollection.InsertOne(ctx, models.OutboxMessage{
+		From:            msg.From,
+		To:              msg.To,
+		Subject:         msg.Subject,
+		HTML:            msg.HTML,
+		Text:            msg.Text,
+		Status:          models.OutboxPending,
+		Next_attempt_at: now,
+		Created_at:      now,
+		Updated_at:      now,
+	})
+	return err
+}
+
+// ListOutbox returns one page of messages, optionally only the ones in the given status.
+func ListOutbox(ctx context.Context, status string, page int, recordPerPage int) ([]models.OutboxMessage, int64, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	total, err := outboxCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * recordPerPage)).
+		SetLimit(int64(recordPerPage))
+	cursor, err := outboxCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	messages := []models.OutboxMessage{}
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, 0, err
+	}
+	return messages, total, nil
+}
+
+// DeleteOutboxMessagesTo removes every message addressed to one of the emails, whatever its status,
+// the purge of an account uses it so no copy of what was sent to the user stays behind.
+func DeleteOutboxMessagesTo(ctx context.Context, emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	_, err := outboxCollection.DeleteMany(ctx, bson.M{"to": bson.M{"$in": emails}})
+	return err
+}
+
+var ErrOutboxMessageNotFound = errors.New("outbox message not found")
+
+// RetryOutboxMessage puts a failed or dead message back in the queue with a fresh attempt count.
+func RetryOutboxMessage(ctx context.Context, id primitive.ObjectID) error {
+	result, err := outboxCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": bson.M{"$ne": models.OutboxSent}},
+		bson.M{"$set": bson.M{
+			"status":          models.OutboxPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"updated_at":      time.Now(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrOutboxMessageNotFound
+	}
+	return nil
+}
+
+// OutboxWorker delivers the queued messages with the real transport.
+// A failed message is retried with exponential backoff, after MaxAttempts it is dead-lettered
+// and stays there until an admin retries it.
+type OutboxWorker struct {
+	Mailer       Mailer
+	Workers      int
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	PollInterval time.Duration
+	// how long a worker owns a message, after that another worker may pick it up again.
+	Lease time.Duration
+	// how long the sent and the dead messages are kept before the prune removes them.
+	SentRetention time.Duration
+	DeadRetention time.Duration
+	PruneInterval time.Duration
+}
+
+func NewOutboxWorker(mailer Mailer) *OutboxWorker {
+	return &OutboxWorker{
+		Mailer:        mailer,
+		Workers:       envInt("OUTBOX_WORKERS", 2),
+		MaxAttempts:   envInt("OUTBOX_MAX_ATTEMPTS", 8),
+		BaseDelay:     envDuration("OUTBOX_BASE_DELAY", 30*time.Second),
+		MaxDelay:      envDuration("OUTBOX_MAX_DELAY", time.Hour),
+		PollInterval:  envDuration("OUTBOX_POLL_INTERVAL", 2*time.Second),
+		Lease:         2 * time.Minute,
+		SentRetention: envDuration("OUTBOX_SENT_RETENTION", 7*24*time.Hour),
+		DeadRetention: envDuration("OUTBOX_DEAD_RETENTION", 30*24*time.Hour),
+		PruneInterval: envDuration("OUTBOX_PRUNE_INTERVAL", time.Hour),
+	}
+}
+
+// Run blocks until ctx is cancelled.
+func (w *OutboxWorker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < w.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.pruneLoop(ctx)
+	}()
+	wg.Wait()
+}
+
+func (w *OutboxWorker) pruneLoop(ctx context.Context) {
+	for {
+		if _, err := w.Prune(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("outbox: failed to prune old messages: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.PruneInterval):
+		}
+	}
+}
+
+// Prune removes the sent messages older than SentRetention and the dead ones older than DeadRetention,
+// the pending ones are never touched.
+func (w *OutboxWorker) Prune(ctx context.Context) (int64, error) {
+	now := time.Now()
+	result, err := outboxCollection.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"status": models.OutboxSent, "updated_at": bson.M{"$lt": now.Add(-w.SentRetention)}},
+		{"status": models.OutboxDead, "updated_at": bson.M{"$lt": now.Add(-w.DeadRetention)}},
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (w *OutboxWorker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		msg, err := w.claim(ctx)
+		if err != nil {
+			if err != mongo.ErrNoDocuments && ctx.Err() == nil {
+				log.Printf("outbox: failed to claim a message: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.PollInterval):
+			}
+			continue
+		}
+		w.deliver(ctx, msg)
+	}
+}
+
+// claim takes the next due message, FindOneAndUpdate makes sure only one worker gets it.
+func (w *OutboxWorker) claim(ctx context.Context) (models.OutboxMessage, error) {
+	var msg models.OutboxMessage
+	now := time.Now()
+
+	filter := bson.M{"$or": []bson.M{
+		{"status": models.OutboxPending, "next_attempt_at": bson.M{"$lte": now}},
+		// a worker that died in the middle of a delivery leaves the message in sending.
+		{"status": models.OutboxSending, "locked_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"status": models.OutboxSending, "locked_until": now.Add(w.Lease), "updated_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	err := outboxCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&msg)
+	return msg, err
+}
+
+func (w *OutboxWorker) deliver(ctx context.Context, msg models.OutboxMessage) {
+	sendCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	err := w.Mailer.Send(sendCtx, Message{
+		From:    msg.From,
+		To:      msg.To,
+		Subject: msg.Subject,
+		HTML:    msg.HTML,
+		Text:    msg.Text,
+	})
+	cancel()
+
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	switch {
+	case err == nil:
+		set["status"] = models.OutboxSent
+		set["sent_at"] = now
+		// the body holds the links and the codes, once delivered there is no reason to keep it.
+		set["html"] = ""
+		set["text"] = ""
+	case msg.Attempts >= w.MaxAttempts:
+		log.Printf("outbox: giving up on message %s after %d attempts: %v", msg.ID.Hex(), msg.Attempts, err)
+		set["status"] = models.OutboxDead
+		set["last_error"] = err.Error()
+	default:
+		set["status"] = models.OutboxPending
+		set["last_error"] = err.Error()
+		set["next_attempt_at"] = now.Add(w.backoff(msg.Attempts))
+	}
+
+	updateCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err = outboxCollection.UpdateOne(updateCtx, bson.M{"_id": msg.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("outbox: failed to update message %s: %v", msg.ID.Hex(), err)
+	}
+}
+
+// backoff doubles the delay on every attempt, with some jitter so retries don't line up.
+func (w *OutboxWorker) backoff(attempts int) time.Duration {
+	delay := w.BaseDelay
+	for i := 1; i < attempts && delay < w.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > w.MaxDelay {
+		delay = w.MaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
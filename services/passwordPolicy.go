@@ -0,0 +1,270 @@
+package services
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"jwtauth/hashing"
+)
+
+// PasswordViolation is one failed rule, the api returns the whole list so a client can show
+// every problem at once.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	// in bytes, not characters, it is what the hashers limit.
+	MaxLength int
+	// how many of lowercase, uppercase, digits and symbols must be used.
+	MinClasses int
+	// the password may not contain the email or the name of the user.
+	DisallowPersonal bool
+	// 0 (too guessable) to 4 (very unguessable), see PasswordStrength.
+	MinStrength int
+	// reject passwords found in the breached password list.
+	CheckBreached bool
+}
+
+// PasswordPolicyFromEnv reads the PASSWORD_* settings. With bcrypt the maximum length is never
+// more than the 72 bytes bcrypt can hash, a longer password would only fail later with a 500.
+func PasswordPolicyFromEnv() PasswordPolicy {
+	maxLength := envInt("PASSWORD_MAX_LENGTH", 128)
+	if _, ok := hashing.Current().(hashing.BcryptHasher); ok && maxLength > hashing.BcryptMaxLength {
+		maxLength = hashing.BcryptMaxLength
+	}
+	return PasswordPolicy{
+		MinLength:        envInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:        maxLength,
+		MinClasses:       envInt("PASSWORD_MIN_CLASSES", 2),
+		DisallowPersonal: strings.ToLower(os.Getenv("PASSWORD_ALLOW_PERSONAL_INFO")) != "true",
+		MinStrength:      envInt("PASSWORD_MIN_STRENGTH", 2),
+		CheckBreached:    true,
+	}
+}
+
+// PasswordHistorySize is how many previous passwords can't be used again, 0 turns it off.
+func PasswordHistorySize() int {
+	return envInt("PASSWORD_HISTORY_SIZE", 5)
+}
+
+// PasswordMaxAge is how long a password can be used before it has to be changed, 0 means forever.
+func PasswordMaxAge() time.Duration {
+	return envDuration("PASSWORD_MAX_AGE", 0)
+}
+
+// CheckPassword applies the configured policy, personal is the email and names of the user.
+func CheckPassword(password string, personal ...string) []PasswordViolation {
+	return PasswordPolicyFromEnv().Check(password, personal...)
+}
+
+func (p PasswordPolicy) Check(password string, personal ...string) []PasswordViolation {
+	violations := []PasswordViolation{}
+	add := func(rule string, message string) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: message})
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		add("min_length", "password must be at least "+strconv.Itoa(p.MinLength)+" characters long")
+	}
+	// accented letters and emoji take 2 to 4 bytes each.
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		add("max_length", "password must be at most "+strconv.Itoa(p.MaxLength)+" bytes long, accented letters and emoji count as more than one")
+	}
+
+	if classes := characterClasses(password); classes < p.MinClasses {
+		add("character_classes", "password must use at least "+strconv.Itoa(p.MinClasses)+" of lowercase letters, uppercase letters, digits and symbols")
+	}
+
+	if p.DisallowPersonal {
+		lower := strings.ToLower(password)
+		for _, info := range personalParts(personal) {
+			if strings.Contains(lower, info) {
+				add("personal_info", "password must not contain your name or email")
+				break
+			}
+		}
+	}
+
+	if p.MinStrength > 0 && PasswordStrength(password) < p.MinStrength {
+		add("strength", "password is too easy to guess, try a longer password or a few unrelated words")
+	}
+
+	if p.CheckBreached {
+		if breached, _ := IsBreachedPassword(password); breached {
+			add("breached", "password has appeared in a data breach, please choose another one")
+		}
+	}
+	return violations
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// personalParts splits the email and names into the pieces worth looking for, short ones
+// like "li" would reject too many good passwords.
+func personalParts(personal []string) []string {
+	var parts []string
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if at := strings.Index(value, "@"); at >= 0 {
+			value = value[:at]
+		}
+		for _, part := range strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if utf8.RuneCountInString(part) >= 3 {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return parts
+}
+
+// PasswordStrength estimates how many guesses a password takes, in the spirit of zxcvbn:
+// common words, repeats, sequences and keyboard runs count as a single cheap guess each,
+// every other character multiplies by the size of its alphabet. The score is 0 to 4.
+func PasswordStrength(password string) int {
+	guesses := estimateGuessesLog10(password)
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	}
+	return 4
+}
+
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+func estimateGuessesLog10(password string) float64 {
+	runes := []rune(strings.ToLower(password))
+	charset := 0
+	for _, size := range []struct {
+		class func(rune) bool
+		size  int
+	}{
+		{unicode.IsLower, 26}, {unicode.IsUpper, 26}, {unicode.IsDigit, 10},
+		{func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }, 33},
+	} {
+		for _, r := range password {
+			if size.class(r) {
+				charset += size.size
+				break
+			}
+		}
+	}
+	if charset == 0 {
+		return 0
+	}
+	perChar := math.Log10(float64(charset))
+
+	total := 0.0
+	for i := 0; i < len(runes); {
+		length, guesses := longestPattern(runes, i)
+		if length == 0 {
+			total += perChar
+			i++
+			continue
+		}
+		total += guesses
+		i += length
+	}
+	return total
+}
+
+// longestPattern returns the length of the cheapest to guess pattern starting at i,
+// and the log10 of the guesses it costs.
+func longestPattern(runes []rune, i int) (int, float64) {
+	bestLength, bestGuesses := 0, 0.0
+	consider := func(length int, guesses float64) {
+		if length > bestLength {
+			bestLength, bestGuesses = length, guesses
+		}
+	}
+
+	// common passwords and words, with the usual letter substitutions undone.
+	raw, normalized := string(runes[i:]), unleet(runes[i:])
+	for rank, word := range commonWords {
+		if len(word) > bestLength && (strings.HasPrefix(raw, word) || strings.HasPrefix(normalized, word)) {
+			consider(len(word), math.Log10(float64(rank+2))+1)
+		}
+	}
+
+	// aaaa, 1111
+	j := i + 1
+	for j < len(runes) && runes[j] == runes[i] {
+		j++
+	}
+	if j-i >= 3 {
+		consider(j-i, math.Log10(float64(10*(j-i))))
+	}
+
+	// abcd, 4321
+	for _, step := range []rune{1, -1} {
+		j = i + 1
+		for j < len(runes) && runes[j]-runes[j-1] == step {
+			j++
+		}
+		if j-i >= 3 {
+			consider(j-i, math.Log10(float64(20*(j-i))))
+		}
+	}
+
+	// qwerty, asdf
+	for _, row := range keyboardRows {
+		start := strings.IndexRune(row, runes[i])
+		if start < 0 {
+			continue
+		}
+		j = i + 1
+		for j < len(runes) && start+(j-i) < len(row) && rune(row[start+(j-i)]) == runes[j] {
+			j++
+		}
+		if j-i >= 3 {
+			consider(j-i, math.Log10(float64(40*(j-i))))
+		}
+	}
+	return bestLength, bestGuesses
+}
+
+func unleet(runes []rune) string {
+	replacer := strings.NewReplacer("@", "a", "4", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+	return replacer.Replace(string(runes))
+}
+
+// a short list of the most used passwords and words in them, ordered by how common they are.
+var commonWords = []string{
+	"password", "123456", "qwerty", "admin", "welcome", "letmein", "monkey", "dragon", "football",
+	"baseball", "iloveyou", "master", "sunshine", "princess", "shadow", "superman", "michael",
+	"login", "starwars", "trustno1", "hello", "freedom", "whatever", "qazwsx", "ninja", "mustang",
+	"access", "batman", "passw", "secret", "summer", "winter", "spring", "autumn", "flower",
+	"cheese", "computer", "internet", "soccer", "hockey", "killer", "pepper", "jordan", "harley",
+	"ranger", "buster", "thomas", "tigger", "robert", "hunter", "charlie", "andrew",
+	"love", "india", "pass", "test", "user", "root", "guest", "default", "changeme", "abc",
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func violatedRules(violations []PasswordViolation) []string {
+	rules := []string{}
+	for _, v := range violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	// every rule off, each case turns on the one it is about.
+	base := PasswordPolicy{}
+	with := func(change func(*PasswordPolicy)) PasswordPolicy {
+		p := base
+		change(&p)
+		return p
+	}
+
+	cases := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		personal []string
+		want     []string
+	}{
+		{"no rules", base, "x", nil, []string{}},
+		{"too short", with(func(p *PasswordPolicy) { p.MinLength = 8 }), "Ab1!xyz", nil, []string{"min_length"}},
+		{"min length counts characters", with(func(p *PasswordPolicy) { p.MinLength = 8 }), "éééééééé", nil, []string{}},
+		{"long enough", with(func(p *PasswordPolicy) { p.MinLength = 8 }), "Ab1!wxyz", nil, []string{}},
+		{"too long", with(func(p *PasswordPolicy) { p.MaxLength = 10 }), "abcdefghijk", nil, []string{"max_length"}},
+		{"max length counts bytes", with(func(p *PasswordPolicy) { p.MaxLength = 10 }), "éééééé", nil, []string{"max_length"}},
+		{"at the max length", with(func(p *PasswordPolicy) { p.MaxLength = 10 }), "ééééé", nil, []string{}},
+		{"one class", with(func(p *PasswordPolicy) { p.MinClasses = 2 }), "abcdefgh", nil, []string{"character_classes"}},
+		{"two classes", with(func(p *PasswordPolicy) { p.MinClasses = 2 }), "abcdefg1", nil, []string{}},
+		{"all four classes", with(func(p *PasswordPolicy) { p.MinClasses = 4 }), "aB1!", nil, []string{}},
+		{"guessable", with(func(p *PasswordPolicy) { p.MinStrength = 2 }), "password123", nil, []string{"strength"}},
+		{"keyboard run", with(func(p *PasswordPolicy) { p.MinStrength = 2 }), "qwertyuiop", nil, []string{"strength"}},
+		{"unguessable", with(func(p *PasswordPolicy) { p.MinStrength = 2 }), "vK7#pQ2!mZ9x", nil, []string{}},
+		{"every failure at once", with(func(p *PasswordPolicy) { p.MinLength = 12; p.MinClasses = 3; p.MinStrength = 2 }), "aaaa", nil,
+			[]string{"min_length", "character_classes", "strength"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			violations := tc.policy.Check(tc.password, tc.personal...)
+			if got := violatedRules(violations); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Check(%q) rules = %v, want %v", tc.password, got, tc.want)
+			}
+			for _, v := range violations {
+				if v.Message == "" {
+					t.Errorf("rule %s has no message", v.Rule)
+				}
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyPersonalInfo(t *testing.T) {
+	policy := PasswordPolicy{DisallowPersonal: true}
+	personal := []string{"Jane.Doe-Smith@example.com", "Jane", "Li"}
+
+	cases := []struct {
+		password string
+		want     bool
+	}{
+		{"xxJANExx99", true},     // the first name, any case
+		{"my-smith-pass", true},  // a part of the email's local part
+		{"doe!doe!doe", true},    // another part
+		{"example2024!", false},  // the domain is not personal
+		{"Lightning42", false},   // "li" is too short to look for
+		{"Correct-Horse", false}, // nothing personal
+	}
+	for _, tc := range cases {
+		t.Run(tc.password, func(t *testing.T) {
+			got := len(policy.Check(tc.password, personal...)) > 0
+			if got != tc.want {
+				t.Errorf("Check(%q) personal_info = %v, want %v", tc.password, got, tc.want)
+			}
+		})
+	}
+
+	if violations := (PasswordPolicy{}).Check("janedoe", personal...); len(violations) != 0 {
+		t.Errorf("personal info rejected with DisallowPersonal off: %v", violations)
+	}
+}
+
+func TestPasswordPolicyFromEnvMaxLength(t *testing.T) {
+	cases := []struct {
+		name string
+		algo string
+		max  string
+		want int
+	}{
+		{"bcrypt default", "bcrypt", "", 72},
+		{"bcrypt is capped", "bcrypt", "200", 72},
+		{"bcrypt lower limit kept", "bcrypt", "64", 64},
+		{"argon2id default", "argon2id", "", 128},
+		{"argon2id configured", "argon2id", "200", 200},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("PASSWORD_HASH_ALGO", tc.algo)
+			t.Setenv("PASSWORD_MAX_LENGTH", tc.max)
+			if got := PasswordPolicyFromEnv().MaxLength; got != tc.want {
+				t.Errorf("MaxLength = %d, want %d", got, tc.want)
+			}
+		})
+	}
+
+	// a password bcrypt can't hash is a policy violation and not a 500 later on.
+	t.Setenv("PASSWORD_HASH_ALGO", "bcrypt")
+	policy := PasswordPolicyFromEnv()
+	policy.CheckBreached = false
+	got := violatedRules(policy.Check(strings.Repeat("ü", 37) + "Ab1!"))
+	if len(got) != 1 || got[0] != "max_length" {
+		t.Errorf("rules for a 78 byte password = %v, want [max_length]", got)
+	}
+}
+
+// useBreachedList points BREACHED_PASSWORDS_PATH at path and reloads the list.
+func useBreachedList(t *testing.T, path string) {
+	t.Helper()
+	reset := func() {
+		breachedOnce = sync.Once{}
+		breachedHashes = nil
+		breachedDir = ""
+	}
+	reset()
+	t.Cleanup(reset)
+	t.Setenv("BREACHED_PASSWORDS_PATH", path)
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestIsBreachedPassword(t *testing.T) {
+	dir := t.TempDir()
+
+	// the single file format, SHA1:COUNT.
+	listFile := filepath.Join(dir, "breached.txt")
+	list := sha1Hex("hunter2") + ":120\n" + strings.ToLower(sha1Hex("rarely-seen")) + ":1\n"
+	if err := os.WriteFile(listFile, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the range format, one file per prefix with SUFFIX:COUNT lines.
+	rangeDir := filepath.Join(dir, "ranges")
+	if err := os.Mkdir(rangeDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	hash := sha1Hex("hunter2")
+	if err := os.WriteFile(filepath.Join(rangeDir, hash[:5]+".txt"), []byte("0000000000000000000000000000000000A:3\r\n"+hash[5:]+":120\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		path     string
+		minCount string
+		password string
+		want     bool
+	}{
+		{"no list", "", "", "hunter2", false},
+		{"missing list", filepath.Join(dir, "missing"), "", "hunter2", false},
+		{"file, listed", listFile, "", "hunter2", true},
+		{"file, lowercase hash", listFile, "", "rarely-seen", true},
+		{"file, not listed", listFile, "", "correct horse battery staple", false},
+		{"file, under the min count", listFile, "10", "rarely-seen", false},
+		{"file, over the min count", listFile, "10", "hunter2", true},
+		{"ranges, listed", rangeDir, "", "hunter2", true},
+		{"ranges, no file for the prefix", rangeDir, "", "correct horse battery staple", false},
+		{"ranges, under the min count", rangeDir, "500", "hunter2", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useBreachedList(t, tc.path)
+			t.Setenv("BREACHED_PASSWORD_MIN_COUNT", tc.minCount)
+			got, err := IsBreachedPassword(tc.password)
+			if err != nil {
+				t.Fatalf("IsBreachedPassword: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("IsBreachedPassword(%q) = %v, want %v", tc.password, got, tc.want)
+			}
+		})
+	}
+
+	// the policy reports it as its own rule.
+	useBreachedList(t, listFile)
+	if got := violatedRules((PasswordPolicy{CheckBreached: true}).Check("hunter2")); !reflect.DeepEqual(got, []string{"breached"}) {
+		t.Errorf("rules = %v, want [breached]", got)
+	}
+}
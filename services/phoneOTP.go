@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// settings of the sms codes that confirm a phone number.
+
+func PhoneOTPTTL() time.Duration {
+	return envDuration("PHONE_OTP_TTL", 10*time.Minute)
+}
+
+func PhoneOTPCooldown() time.Duration {
+	return envDuration("PHONE_OTP_COOLDOWN", time.Minute)
+}
+
+func PhoneOTPMaxAttempts() int {
+	return envInt("PHONE_OTP_MAX_ATTEMPTS", 5)
+}
+
+// every sms costs money, so there are only a few a day for each user.
+func PhoneOTPDailyCap() int {
+	return envInt("PHONE_OTP_DAILY_CAP", 5)
+}
+
+// CheckPhoneOTPAllowed is CheckResendAllowed for the sms codes, with their own cooldown and daily cap.
+func CheckPhoneOTPAllowed(lastSent time.Time, sentDay string, sentCount int, now time.Time) (time.Duration, bool) {
+	if wait := lastSent.Add(PhoneOTPCooldown()).Sub(now); wait > 0 {
+		return wait, false
+	}
+	if sentDay == SendDay(now) && sentCount >= PhoneOTPDailyCap() {
+		y, m, d := now.UTC().Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Sub(now), false
+	}
+	return 0, true
+}
+
+// SendPhoneOTP texts the code with the configured sms provider.
+func SendPhoneOTP(ctx context.Context, phone string, code string) error {
+	body := fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(PhoneOTPTTL().Minutes()))
+	return DefaultSMSSender().SendSMS(ctx, phone, body)
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckPhoneOTPAllowed(t *testing.T) {
+	t.Setenv("PHONE_OTP_COOLDOWN", "1m")
+	t.Setenv("PHONE_OTP_DAILY_CAP", "3")
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	today := SendDay(now)
+
+	cases := []struct {
+		name      string
+		lastSent  time.Time
+		sentDay   string
+		sentCount int
+		allowed   bool
+		wait      time.Duration
+	}{
+		{name: "never sent", allowed: true},
+		{name: "in the cooldown", lastSent: now.Add(-20 * time.Second), sentDay: today, sentCount: 1, wait: 40 * time.Second},
+		{name: "after the cooldown", lastSent: now.Add(-2 * time.Minute), sentDay: today, sentCount: 2, allowed: true},
+		{name: "daily cap reached", lastSent: now.Add(-2 * time.Minute), sentDay: today, sentCount: 3, wait: 12 * time.Hour},
+		{name: "cap of another day", lastSent: now.Add(-13 * time.Hour), sentDay: SendDay(now.Add(-13 * time.Hour)), sentCount: 3, allowed: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			wait, ok := CheckPhoneOTPAllowed(tc.lastSent, tc.sentDay, tc.sentCount, now)
+			if ok != tc.allowed || wait != tc.wait {
+				t.Errorf("CheckPhoneOTPAllowed = %s, %v, want %s, %v", wait, ok, tc.wait, tc.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"jwtauth/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimitPolicy is a token bucket, it holds up to Limit requests and refills Limit tokens every Period.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimitResult says if the request may go on, and what goes into the RateLimit-* headers.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error)
+}
+
+// ParseRateLimit reads a policy like "10/1m", which means 10 requests per minute.
+func ParseRateLimit(name string, spec string) (RateLimitPolicy, error) {
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 {
+		return RateLimitPolicy{}, fmt.Errorf("invalid rate limit %q, expected like 10/1m", spec)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit < 1 {
+		return RateLimitPolicy{}, fmt.Errorf("invalid rate limit %q, expected like 10/1m", spec)
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || period <= 0 {
+		return RateLimitPolicy{}, fmt.Errorf("invalid rate limit %q, expected like 10/1m", spec)
+	}
+	return RateLimitPolicy{Name: name, Limit: limit, Period: period}, nil
+}
+
+// RateLimitPolicyFromEnv reads RATE_LIMIT_<NAME>, like RATE_LIMIT_LOGIN_IP=20/1m, or uses the default.
+func RateLimitPolicyFromEnv(name string, fallback string) RateLimitPolicy {
+	key := "RATE_LIMIT_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+	if spec := os.Getenv(key); spec != "" {
+		policy, err := ParseRateLimit(name, spec)
+		if err == nil {
+			return policy
+		}
+		log.Printf("Invalid %s: %v, using %s", key, err, fallback)
+	}
+	policy, err := ParseRateLimit(name, fallback)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+var (
+	defaultRateLimitStore RateLimitStore
+	rateLimitStoreOnce    sync.Once
+)
+
+// DefaultRateLimitStore keeps the counters in memory, with RATE_LIMIT_STORE=mongo they are shared
+// by every instance of the api.
+func DefaultRateLimitStore() RateLimitStore {
+	rateLimitStoreOnce.Do(func() {
+		if strings.ToLower(os.Getenv("RATE_LIMIT_STORE")) == "mongo" {
+			defaultRateLimitStore = NewMongoRateLimitStore(database.OpenCollection(database.Client, "rate_limits"))
+		} else {
+			defaultRateLimitStore = NewMemoryRateLimitStore()
+		}
+	})
+	return defaultRateLimitStore
+}
+
+// result works out the headers from the tokens left in the bucket.
+func rateLimitResult(policy RateLimitPolicy, tokens float64, allowed bool) RateLimitResult {
+	perToken := float64(policy.Period) / float64(policy.Limit)
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(policy.Limit) - tokens) * perToken),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+	return result
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryRateLimitStore is enough for a single instance.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*bucket{}, swept: time.Now()}
+}
+
+func (m *MemoryRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now, policy.Period)
+
+	b := m.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(policy.Limit), updated: now}
+		m.buckets[key] = b
+	}
+
+	rate := float64(policy.Limit) / float64(policy.Period)
+	b.tokens = math.Min(float64(policy.Limit), b.tokens+float64(now.Sub(b.updated))*rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return rateLimitResult(policy, b.tokens, false), nil
+	}
+	b.tokens--
+	return rateLimitResult(policy, b.tokens, true), nil
+}
+
+// sweep drops the buckets nobody used for a while, so the map doesn't grow forever.
+func (m *MemoryRateLimitStore) sweep(now time.Time, period time.Duration) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	idle := period
+	if idle < time.Hour {
+		idle = time.Hour
+	}
+	for key, b := range m.buckets {
+		if now.Sub(b.updated) > idle {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// MongoRateLimitStore keeps one document per bucket, the refill and the take happen in one
+// pipeline update on the server (with the server's clock), so concurrent instances can't race.
+type MongoRateLimitStore struct {
+	collection *mongo.Collection
+	indexOnce  sync.Once
+}
+
+func NewMongoRateLimitStore(collection *mongo.Collection) *MongoRateLimitStore {
+	return &MongoRateLimitStore{collection: collection}
+}
+
+func (m *MongoRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	m.indexOnce.Do(func() {
+		// old buckets are removed by mongo itself.
+		_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			log.Printf("Failed to create the rate limit index: %v", err)
+		}
+	})
+
+	limit := float64(policy.Limit)
+	ratePerMs := limit / float64(policy.Period.Milliseconds())
+	refilled := bson.M{"$min": bson.A{
+		limit,
+		bson.M{"$add": bson.A{
+			bson.M{"$ifNull": bson.A{"$tokens", limit}},
+			bson.M{"$multiply": bson.A{
+				bson.M{"$subtract": bson.A{"$$NOW", bson.M{"$ifNull": bson.A{"$updated_at", "$$NOW"}}}},
+				ratePerMs,
+			}},
+		}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled, "updated_at": "$$NOW"}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed": bson.M{"$gte": bson.A{"$tokens", 1}},
+			"tokens": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$tokens", 1}},
+				bson.M{"$subtract": bson.A{"$tokens", 1}},
+				"$tokens",
+			}},
+			"expires_at": bson.M{"$add": bson.A{"$$NOW", policy.Period.Milliseconds()}},
+		}}},
+	}
+
+	var doc struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	err := m.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&doc)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return rateLimitResult(policy, doc.Tokens, doc.Allowed), nil
+}
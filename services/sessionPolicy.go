@@ -0,0 +1,28 @@
+package services
+
+import (
+	"time"
+)
+
+// a session ends after SESSION_IDLE_TIMEOUT_<TYPE> without any request, and after
+// SESSION_MAX_LIFETIME_<TYPE> since the login however much it is used. the admins get shorter ones.
+
+func SessionIdleTimeout(userType string) time.Duration {
+	if userType == "ADMIN" {
+		return envDuration("SESSION_IDLE_TIMEOUT_ADMIN", 30*time.Minute)
+	}
+	return envDuration("SESSION_IDLE_TIMEOUT_USER", 7*24*time.Hour)
+}
+
+func SessionMaxLifetime(userType string) time.Duration {
+	if userType == "ADMIN" {
+		return envDuration("SESSION_MAX_LIFETIME_ADMIN", 12*time.Hour)
+	}
+	return envDuration("SESSION_MAX_LIFETIME_USER", 30*24*time.Hour)
+}
+
+// StepUpMaxAge is how recent the last login or reauthentication has to be for the sensitive
+// routes, see STEP_UP_MAX_AGE.
+func StepUpMaxAge() time.Duration {
+	return envDuration("STEP_UP_MAX_AGE", 10*time.Minute)
+}
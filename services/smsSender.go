@@ -0,0 +1,137 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMSSender is implemented by every sms provider.
+type SMSSender interface {
+	SendSMS(ctx context.Context, to string, body string) error
+}
+
+var (
+	defaultSMSSender SMSSender
+	smsMu            sync.Mutex
+)
+
+// DefaultSMSSender returns the provider selected by SMS_TRANSPORT (log, memory or http).
+func DefaultSMSSender() SMSSender {
+	smsMu.Lock()
+	defer smsMu.Unlock()
+
+	if defaultSMSSender == nil {
+		switch strings.ToLower(os.Getenv("SMS_TRANSPORT")) {
+		case "http":
+			endpoint := os.Getenv("SMS_HTTP_ENDPOINT")
+			if endpoint == "" {
+				log.Printf("SMS_HTTP_ENDPOINT is not set, falling back to the log transport")
+				defaultSMSSender = &LogSMSSender{}
+				break
+			}
+			defaultSMSSender = NewHTTPSMSSender(endpoint, os.Getenv("SMS_HTTP_API_KEY"), os.Getenv("SMS_FROM"))
+		case "memory":
+			defaultSMSSender = NewMemorySMSSender()
+		default:
+			defaultSMSSender = &LogSMSSender{}
+		}
+	}
+	return defaultSMSSender
+}
+
+// SetDefaultSMSSender replaces the provider, for example with a MemorySMSSender in tests.
+func SetDefaultSMSSender(sender SMSSender) {
+	smsMu.Lock()
+	defer smsMu.Unlock()
+	defaultSMSSender = sender
+}
+
+// LogSMSSender only prints the message, handy in local development.
+type LogSMSSender struct{}
+
+func (l *LogSMSSender) SendSMS(ctx context.Context, to string, body string) error {
+	log.Printf("sms to %s: %s", to, body)
+	return nil
+}
+
+type SMS struct {
+	To   string
+	Body string
+}
+
+// MemorySMSSender keeps every message it gets, so tests can read the codes back.
+type MemorySMSSender struct {
+	mu       sync.Mutex
+	messages []SMS
+}
+
+func NewMemorySMSSender() *MemorySMSSender {
+	return &MemorySMSSender{}
+}
+
+func (m *MemorySMSSender) SendSMS(ctx context.Context, to string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, SMS{To: to, Body: body})
+	return nil
+}
+
+func (m *MemorySMSSender) Messages() []SMS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SMS(nil), m.messages...)
+}
+
+// HTTPSMSSender posts the message as json to a sms provider api, or to a local stub server.
+type HTTPSMSSender struct {
+	Endpoint string
+	APIKey   string
+	From     string
+	Client   *http.Client
+}
+
+func NewHTTPSMSSender(endpoint string, apiKey string, from string) *HTTPSMSSender {
+	return &HTTPSMSSender{
+		Endpoint: endpoint,
+		APIKey:   apiKey,
+		From:     from,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (h *HTTPSMSSender) SendSMS(ctx context.Context, to string, body string) error {
+	payload, err := json.Marshal(map[string]string{"from": h.From, "to": to, "body": body})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sms api returned %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSMSSenderSend(t *testing.T) {
+	var got map[string]string
+	var auth, contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		auth = r.Header.Get("Authorization")
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding the request: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	sender := NewHTTPSMSSender(server.URL, "secret-key", "+15550001111")
+	if err := sender.SendSMS(context.Background(), "+916386402690", "Your verification code is 123456."); err != nil {
+		t.Fatalf("SendSMS: %v", err)
+	}
+
+	if auth != "Bearer secret-key" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	want := map[string]string{"from": "+15550001111", "to": "+916386402690", "body": "Your verification code is 123456."}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("payload[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestHTTPSMSSenderNoAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Header["Authorization"]; ok {
+			t.Errorf("Authorization header sent without an api key")
+		}
+	}))
+	defer server.Close()
+
+	if err := NewHTTPSMSSender(server.URL, "", "").SendSMS(context.Background(), "+916386402690", "hi"); err != nil {
+		t.Fatalf("SendSMS: %v", err)
+	}
+}
+
+func TestHTTPSMSSenderErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unknown number", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := NewHTTPSMSSender(server.URL, "", "").SendSMS(context.Background(), "+916386402690", "hi")
+	if err == nil {
+		t.Fatal("SendSMS succeeded on a 400")
+	}
+	if !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "unknown number") {
+		t.Errorf("error = %q, want the status and the body", err)
+	}
+}
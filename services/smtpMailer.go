@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTPMailer delivers the messages to a smtp server.
+// Security can be "starttls", "tls" (implicit tls, usually port 465) or "none",
+// and Auth can be "plain", "login", "cram-md5" or "none".
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Security string
+	Auth     string
+	Timeout  time.Duration
+}
+
+func NewSMTPMailerFromEnv() (*SMTPMailer, error) {
+	m := &SMTPMailer{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("EMAIL_PASSWORD"),
+		Security: strings.ToLower(os.Getenv("SMTP_SECURITY")),
+		Auth:     strings.ToLower(os.Getenv("SMTP_AUTH")),
+		Timeout:  30 * time.Second,
+	}
+	if m.Host == "" {
+		return nil, errors.New("SMTP_HOST is required for the smtp transport")
+	}
+	if m.Port == "" {
+		m.Port = "587"
+	}
+	if m.Username == "" {
+		m.Username = os.Getenv("EMAIL_FROM")
+	}
+	if m.Security == "" {
+		m.Security = "starttls"
+		if m.Port == "465" {
+			m.Security = "tls"
+		}
+	}
+	if m.Auth == "" {
+		m.Auth = "plain"
+	}
+
+	switch m.Security {
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("unknown SMTP_SECURITY %q", m.Security)
+	}
+	switch m.Auth {
+	case "plain", "login", "cram-md5", "none":
+	default:
+		return nil, fmt.Errorf("unknown SMTP_AUTH %q", m.Auth)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(m.Host, m.Port)
+	tlsConfig := &tls.Config{ServerName: m.Host}
+
+	dialer := &net.Dialer{Timeout: m.Timeout}
+	var conn net.Conn
+	var err error
+	if m.Security == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the whole conversation with the server must finish before the deadline.
+	deadline := time.Now().Add(m.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.Security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if auth := m.auth(); auth != nil {
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(msg.From); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (m *SMTPMailer) auth() smtp.Auth {
+	switch m.Auth {
+	case "plain":
+		return smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	case "login":
+		return &loginAuth{username: m.Username, password: m.Password}
+	case "cram-md5":
+		return smtp.CRAMMD5Auth(m.Username, m.Password)
+	}
+	return nil
+}
+
+// loginAuth is the AUTH LOGIN mechanism, net/smtp only ships PLAIN and CRAM-MD5.
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, errors.New("unencrypted connection, refusing to send the password")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// every locale is a folder inside templates/, with a layout.html and for each email
+// a <name>.html and a <name>.txt, the subject is defined inside the .txt file.
+//
+//go:embed templates
+var templateFS embed.FS
+
+const DefaultLocale = "en"
+
+type localeTemplates struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+var emailTemplates = mustLoadTemplates(templateFS)
+
+func mustLoadTemplates(fsys fs.FS) map[string]*localeTemplates {
+	locales, err := fs.ReadDir(fsys, "templates")
+	if err != nil {
+		panic(err)
+	}
+
+	all := map[string]*localeTemplates{}
+	for _, locale := range locales {
+		if !locale.IsDir() {
+			continue
+		}
+		dir := path.Join("templates", locale.Name())
+		set := &localeTemplates{
+			html: map[string]*htmltemplate.Template{},
+			text: map[string]*texttemplate.Template{},
+		}
+
+		files, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			panic(err)
+		}
+		for _, file := range files {
+			name := file.Name()
+			switch {
+			case name == "layout.html":
+			case strings.HasSuffix(name, ".html"):
+				set.html[strings.TrimSuffix(name, ".html")] = htmltemplate.Must(
+					htmltemplate.ParseFS(fsys, path.Join(dir, "layout.html"), path.Join(dir, name)))
+			case strings.HasSuffix(name, ".txt"):
+				set.text[strings.TrimSuffix(name, ".txt")] = texttemplate.Must(
+					texttemplate.New(name).ParseFS(fsys, path.Join(dir, name)))
+			}
+		}
+		all[locale.Name()] = set
+	}
+
+	if all[DefaultLocale] == nil {
+		panic("missing email templates for the default locale " + DefaultLocale)
+	}
+	return all
+}
+
+// MatchLocale picks the best template set for a language preference like "es-MX" or
+// an Accept-Language header, anything unknown falls back to the default locale.
+func MatchLocale(preference string) string {
+	for _, part := range strings.Split(preference, ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		if tag == "" {
+			continue
+		}
+		if _, ok := emailTemplates[tag]; ok {
+			return tag
+		}
+		if base := strings.SplitN(tag, "-", 2)[0]; emailTemplates[base] != nil {
+			return base
+		}
+	}
+	return DefaultLocale
+}
+
+// RenderEmail executes the subject, html and plain text parts of a template.
+func RenderEmail(locale string, name string, data interface{}) (subject string, html string, text string, err error) {
+	set := emailTemplates[MatchLocale(locale)]
+	if set.text[name] == nil || set.html[name] == nil {
+		set = emailTemplates[DefaultLocale]
+	}
+	textTmpl, htmlTmpl := set.text[name], set.html[name]
+	if textTmpl == nil || htmlTmpl == nil {
+		return "", "", "", fmt.Errorf("unknown email template %q", name)
+	}
+
+	var buf bytes.Buffer
+	if err = textTmpl.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err = textTmpl.Execute(&buf, data); err != nil {
+		return
+	}
+	text = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err = htmlTmpl.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return
+	}
+	html = buf.String()
+	return
+}
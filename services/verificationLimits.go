@@ -0,0 +1,89 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// how often a verification email may be sent to the same address.
+func VerificationResendCooldown() time.Duration {
+	return envDuration("VERIFY_RESEND_COOLDOWN", time.Minute)
+}
+
+func VerificationDailyCap() int {
+	return envInt("VERIFY_RESEND_DAILY_CAP", 5)
+}
+
+// SendDay is the key of the daily counter, days are counted in UTC.
+func SendDay(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// CheckResendAllowed looks at when the last email went out and how many went out today,
+// if another one is not allowed yet it returns how long the caller has to wait.
+func CheckResendAllowed(lastSent time.Time, sentDay string, sentCount int, now time.Time) (time.Duration, bool) {
+	if wait := lastSent.Add(VerificationResendCooldown()).Sub(now); wait > 0 {
+		return wait, false
+	}
+	if sentDay == SendDay(now) && sentCount >= VerificationDailyCap() {
+		y, m, d := now.UTC().Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Sub(now), false
+	}
+	return 0, true
+}
+
+const (
+	VerificationModeLink = "link"
+	VerificationModeCode = "code"
+)
+
+// VerificationMode picks how a signup is verified, a valid mode asked for in the request wins over
+// VERIFICATION_MODE, which itself defaults to the emailed link.
+func VerificationMode(requested string) string {
+	switch strings.ToLower(requested) {
+	case VerificationModeLink, VerificationModeCode:
+		return strings.ToLower(requested)
+	}
+	if strings.ToLower(os.Getenv("VERIFICATION_MODE")) == VerificationModeCode {
+		return VerificationModeCode
+	}
+	return VerificationModeLink
+}
+
+// a code is much easier to guess than a link, so it lives shorter and allows only a few tries.
+func VerificationCodeTTL() time.Duration {
+	return envDuration("VERIFY_CODE_TTL", 15*time.Minute)
+}
+
+func VerificationCodeMaxAttempts() int {
+	return envInt("VERIFY_CODE_MAX_ATTEMPTS", 5)
+}
+
+// GenerateVerificationCode returns a random 6 digit code, leading zeros included.
+func GenerateVerificationCode() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+// HashVerificationCode keeps only a keyed hash of the code in the database, bound to the email.
+func HashVerificationCode(email string, code string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SECRET_KEY")))
+	mac.Write([]byte(strings.ToLower(email) + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerificationCodeMatches compares in constant time, so the response time says nothing about the code.
+func VerificationCodeMatches(codeHash string, email string, code string) bool {
+	return subtle.ConstantTimeCompare([]byte(codeHash), []byte(HashVerificationCode(email, code))) == 1
+}